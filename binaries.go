@@ -0,0 +1,548 @@
+package pgembed
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBinariesNotFound is returned by New when the PostgreSQL binaries for the
+// requested version are not available locally and may not be downloaded.
+var ErrBinariesNotFound = errors.New("PostgreSQL binaries not found")
+
+// ErrDownloadTimeout is returned by New when the binaries aren't downloaded within
+// Config.DownloadTimeout.
+var ErrDownloadTimeout = errors.New("PostgreSQL binaries download timed out")
+
+// defaultDownloadTimeout is used when Config.DownloadTimeout is 0.
+const defaultDownloadTimeout = 10 * time.Minute
+
+// downloadTimeout returns how long downloading the binaries may take.
+func (c Config) downloadTimeout() time.Duration {
+	if c.DownloadTimeout <= 0 {
+		return defaultDownloadTimeout
+	}
+	return c.DownloadTimeout
+}
+
+// defaultDownloadBaseURL is where the postgresql-binaries release archives are
+// downloaded from when Config.DownloadBaseURL is empty.
+const defaultDownloadBaseURL = "https://github.com/theseus-rs/postgresql-binaries/releases/download"
+
+// staleLockAge is how old an install lock file must be before it is assumed
+// to be left behind by a crashed process. The owner of the lock refreshes its
+// modification time every staleLockAge/4, however long the download takes.
+const staleLockAge = time.Minute
+
+// installLocks serializes installs of the same version within this process;
+// keyed by the version directory. The values are channels with a buffer of 1,
+// holding a value while the lock is held, so that waiting can be canceled.
+var installLocks sync.Map
+
+// exactVersion matches a fully specified version such as "16.0.0".
+var exactVersion = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// defaultCacheDir returns the directory that postgresql-embedded installs
+// downloaded binaries into: one sub-directory per version, e.g.
+// ~/.theseus/postgresql/16.0.0.
+func defaultCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".theseus", "postgresql"), nil
+}
+
+// hasBinaries reports whether dir holds an extracted PostgreSQL distribution,
+// i.e. it has a bin directory containing pg_ctl.
+func hasBinaries(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "bin", executable("pg_ctl")))
+	return err == nil && !info.IsDir()
+}
+
+// systemBinariesPath returns the installation directory of the pg_ctl found on PATH,
+// used without cgo, when the Rust layer isn't available to download the binaries.
+func systemBinariesPath() (string, error) {
+	pgCtl, err := exec.LookPath(executable("pg_ctl"))
+	if err != nil {
+		return "", fmt.Errorf("%w: pg_ctl is not on PATH, install PostgreSQL or set BinariesPath (built without cgo, binaries can't be downloaded)", ErrBinariesNotFound)
+	}
+	if pgCtl, err = filepath.EvalSymlinks(pgCtl); err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", pgCtl, err)
+	}
+	return filepath.Dir(filepath.Dir(pgCtl)), nil
+}
+
+// pgCtlVersion matches the version in the output of pg_ctl --version, e.g. "16.4" in
+// "pg_ctl (PostgreSQL) 16.4 (Ubuntu 16.4-1.pgdg22.04+1)".
+var pgCtlVersion = regexp.MustCompile(`\(PostgreSQL\) (\d+(?:\.\d+){0,2})`)
+
+// binariesVersion returns the version of the binaries in binDir, as an exact version
+// such as "16.4.0" like the ones of the postgresql-binaries releases.
+func binariesVersion(binDir string) (string, error) {
+	output, err := exec.Command(filepath.Join(binDir, executable("pg_ctl")), "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the version of the binaries in %s: %w", binDir, err)
+	}
+	match := pgCtlVersion.FindSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("failed to get the version of the binaries in %s: unexpected pg_ctl --version output %q", binDir, strings.TrimSpace(string(output)))
+	}
+	version := string(match[1])
+	for strings.Count(version, ".") < 2 {
+		version += ".0"
+	}
+	return version, nil
+}
+
+// executable returns the platform specific file name of a PostgreSQL tool.
+func executable(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// platformTarget returns the target triple used in the release archive names
+// for the current platform.
+func platformTarget() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return "x86_64-unknown-linux-gnu", nil
+	case "linux/arm64":
+		return "aarch64-unknown-linux-gnu", nil
+	case "darwin/amd64":
+		return "x86_64-apple-darwin", nil
+	case "darwin/arm64":
+		return "aarch64-apple-darwin", nil
+	case "windows/amd64":
+		return "x86_64-pc-windows-msvc", nil
+	}
+	return "", fmt.Errorf("no PostgreSQL binaries are available for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// downloadURL expands a Config.DownloadBaseURL into the URL of the archive for
+// version. The {version} and {target} placeholders are replaced; a base URL
+// without placeholders is assumed to mirror the layout of the GitHub releases.
+func downloadURL(baseURL, version string) (string, error) {
+	if baseURL == "" {
+		baseURL = defaultDownloadBaseURL
+	}
+	if !strings.Contains(baseURL, "{version}") {
+		baseURL = strings.TrimSuffix(baseURL, "/") + "/{version}/postgresql-{version}-{target}.tar.gz"
+	}
+	target, err := platformTarget()
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("{version}", version, "{target}", target).Replace(baseURL), nil
+}
+
+// installation is where the binaries of a version are, see ensureBinaries.
+type installation struct {
+	// dir is the installation directory to hand to the Rust layer.
+	dir string
+	// trusted reports whether dir holds the binaries directly (BinariesPath) rather
+	// than one sub-directory per version.
+	trusted bool
+	// cached reports whether the binaries were already available rather than
+	// downloaded.
+	cached bool
+	// release marks the binaries as no longer used, see useBinaries.
+	release func()
+}
+
+// ensureBinaries makes sure the binaries for config.Version, which must be an
+// exact version unless BinariesPath is set, are available locally, downloading
+// them if needed. They are marked as used before the install lock is released, so
+// PurgeCache can't remove them in between; the caller must call release.
+func ensureBinaries(config Config) (installation, error) {
+	if config.BinariesPath != "" {
+		absBinariesPath, err := filepath.Abs(config.BinariesPath)
+		if err != nil {
+			return installation{}, fmt.Errorf("failed to get absolute path for BinariesPath: %w", err)
+		}
+		if !hasBinaries(absBinariesPath) {
+			return installation{}, fmt.Errorf("%w in BinariesPath %s", ErrBinariesNotFound, absBinariesPath)
+		}
+		return installation{dir: absBinariesPath, trusted: true, cached: true, release: useBinaries(absBinariesPath)}, nil
+	}
+
+	cacheDir, err := cacheDirectory(config)
+	if err != nil {
+		return installation{}, err
+	}
+	versionDir := filepath.Join(cacheDir, config.Version)
+	ctx, cancel := context.WithTimeout(context.Background(), config.downloadTimeout())
+	defer cancel()
+	// Loops to download them again if they are purged once installed, before
+	// useCachedBinaries gets the lock.
+	for cached := true; ; cached = false {
+		release, err := useCachedBinaries(ctx, versionDir)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return installation{}, fmt.Errorf("%w: PostgreSQL %s was not installed within %s", ErrDownloadTimeout, config.Version, config.downloadTimeout())
+			}
+			return installation{}, err
+		}
+		if release != nil {
+			return installation{dir: cacheDir, cached: cached, release: release}, nil
+		}
+		if config.Offline {
+			return installation{}, fmt.Errorf("%w for version %s in %s", ErrBinariesNotFound, config.Version, cacheDir)
+		}
+		archiveURL, err := downloadURL(config.DownloadBaseURL, config.Version)
+		if err != nil {
+			return installation{}, err
+		}
+		if err := installBinaries(ctx, archiveURL, cacheDir, config.Version, config.DownloadProgress); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return installation{}, fmt.Errorf("%w: PostgreSQL %s was not downloaded within %s", ErrDownloadTimeout, config.Version, config.downloadTimeout())
+			}
+			return installation{}, err
+		}
+	}
+}
+
+// useCachedBinaries marks the binaries in versionDir as used, holding its install
+// lock so that they aren't purged or installed meanwhile, and returns the function
+// releasing them. It returns nil if they aren't installed.
+func useCachedBinaries(ctx context.Context, versionDir string) (func(), error) {
+	if !hasBinaries(versionDir) {
+		return nil, nil
+	}
+	unlock, err := lockInstall(ctx, versionDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if !hasBinaries(versionDir) {
+		return nil, nil // Purged while we were waiting for the lock.
+	}
+	return useBinaries(versionDir), nil
+}
+
+// cacheDirectory returns the absolute Config.CacheDir, or the default cache
+// directory when it is not set.
+func cacheDirectory(config Config) (string, error) {
+	if config.CacheDir == "" {
+		return defaultCacheDir()
+	}
+	absCacheDir, err := filepath.Abs(config.CacheDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for CacheDir: %w", err)
+	}
+	return absCacheDir, nil
+}
+
+// installBinaries downloads the release archive at archiveURL and extracts it
+// into cacheDir/version. The archive is extracted into a temporary directory
+// first, so an interrupted download never leaves a partial installation behind.
+// Concurrent installs of the same version, from this or other processes, are
+// serialized so the archive is only downloaded once. progress, if not nil, is
+// called as the archive is downloaded. ctx bounds the download.
+func installBinaries(ctx context.Context, archiveURL, cacheDir, version string, progress func(downloaded, total int64)) error {
+	if err := os.MkdirAll(cacheDir, 0750); err != nil {
+		return fmt.Errorf("failed to create cache directory %s: %w", cacheDir, err)
+	}
+
+	versionDir := filepath.Join(cacheDir, version)
+	unlock, err := lockInstall(ctx, versionDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if hasBinaries(versionDir) {
+		return nil // Installed while we were waiting for the lock.
+	}
+
+	archive, err := os.CreateTemp(cacheDir, ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	if err := download(ctx, archiveURL, version, archive, progress); err != nil {
+		return err
+	}
+	if err := verifyChecksum(ctx, archiveURL, archive); err != nil {
+		return err
+	}
+
+	extractDir, err := os.MkdirTemp(cacheDir, "."+version+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	defer os.RemoveAll(extractDir)
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := extractArchive(archive, extractDir); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", archiveURL, err)
+	}
+	if !hasBinaries(extractDir) {
+		return fmt.Errorf("archive %s does not contain PostgreSQL binaries", archiveURL)
+	}
+
+	if err := os.Rename(extractDir, versionDir); err != nil {
+		if hasBinaries(versionDir) {
+			return nil // Installed concurrently by someone else.
+		}
+		return fmt.Errorf("failed to install binaries into %s: %w", versionDir, err)
+	}
+	return nil
+}
+
+// lockInstall acquires the install lock for versionDir: a mutex for callers in
+// this process, and a "<versionDir>.lock" file for other processes. Waiting for
+// the lock gives up when ctx is done.
+func lockInstall(ctx context.Context, versionDir string) (func(), error) {
+	value, _ := installLocks.LoadOrStore(versionDir, make(chan struct{}, 1))
+	mu := value.(chan struct{})
+	select {
+	case mu <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for the install of %s: %w", versionDir, ctx.Err())
+	}
+
+	lockFile := versionDir + ".lock"
+	for {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			done := make(chan struct{})
+			go refreshLock(lockFile, done)
+			return func() {
+				close(done)
+				os.Remove(lockFile)
+				<-mu
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			<-mu
+			return nil, fmt.Errorf("failed to create install lock %s: %w", lockFile, err)
+		}
+		if info, err := os.Stat(lockFile); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockFile)
+			continue
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			<-mu
+			return nil, fmt.Errorf("failed to wait for the install lock %s: %w", lockFile, ctx.Err())
+		}
+	}
+}
+
+// refreshLock updates the modification time of lockFile until done is closed, so
+// that other processes don't take it for stale during a long download.
+func refreshLock(lockFile string, done <-chan struct{}) {
+	ticker := time.NewTicker(staleLockAge / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			os.Chtimes(lockFile, now, now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// download fetches url into w, reporting progress if it is not nil.
+func download(ctx context.Context, url, version string, w io.Writer, progress func(downloaded, total int64)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download PostgreSQL %s: %w", version, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("PostgreSQL %s is not available at %s (HTTP 404)", version, url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download PostgreSQL %s from %s: %s", version, url, resp.Status)
+	}
+	if progress != nil {
+		// ContentLength is -1 when the size is unknown.
+		progress(0, resp.ContentLength)
+		w = &progressWriter{w: w, total: resp.ContentLength, progress: progress}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download PostgreSQL %s from %s: %w", version, url, err)
+	}
+	return nil
+}
+
+// progressWriter reports the number of bytes written through it.
+type progressWriter struct {
+	w          io.Writer
+	downloaded int64
+	total      int64
+	progress   func(downloaded, total int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.downloaded += int64(n)
+	pw.progress(pw.downloaded, pw.total)
+	return n, err
+}
+
+// verifyChecksum compares the SHA-256 of archive with the "<archiveURL>.sha256"
+// file published next to it. Mirrors that do not publish checksums are trusted.
+func verifyChecksum(ctx context.Context, archiveURL string, archive io.ReadSeeker) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL+".sha256", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download checksum for %s: %w", archiveURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download checksum for %s: %s", archiveURL, resp.Status)
+	}
+
+	// The file has the sha256sum format: "<hex digest>  <file name>".
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read checksum for %s: %w", archiveURL, err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum for %s", archiveURL)
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, archive); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, fields[0]) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveURL, fields[0], actual)
+	}
+	return nil
+}
+
+// extractArchive extracts a .tar.gz release archive into dir, dropping the
+// top level "postgresql-<version>-<target>" directory of every entry.
+func extractArchive(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, name, found := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !found || name == "" {
+			continue // The top level directory itself.
+		}
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := checkSymlink(dir, path, header.Linkname); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			_, target, _ := strings.Cut(strings.TrimPrefix(header.Linkname, "./"), "/")
+			if !filepath.IsLocal(target) {
+				return fmt.Errorf("invalid link in archive: %s", header.Linkname)
+			}
+			if err := os.Link(filepath.Join(dir, target), path); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkSymlink checks that a symlink at path, in the directory root the archive is
+// extracted to, to linkname stays in root, so that the following entries of the archive
+// can't be written outside of it through the link. path's directory must exist.
+func checkSymlink(root, path, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("invalid link in archive: %s", linkname)
+	}
+	// The directory of the link may be reached through links itself.
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(realRoot, filepath.Join(realDir, linkname))
+	if err != nil || !filepath.IsLocal(target) {
+		return fmt.Errorf("invalid link in archive: %s points outside of the archive", linkname)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package pgembed
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWithMissingBinariesPath(t *testing.T) {
+	binariesPath := tempDir(t)
+	defer os.RemoveAll(binariesPath)
+
+	_, err := New(Config{
+		BinariesPath: binariesPath, // empty, so there is no bin/pg_ctl
+	})
+	if !errors.Is(err, ErrBinariesNotFound) {
+		t.Fatalf("New() error = %v, want ErrBinariesNotFound", err)
+	}
+}
+
+func TestSystemBinariesPath(t *testing.T) {
+	installDir := tempDir(t)
+	defer os.RemoveAll(installDir)
+	binDir := filepath.Join(installDir, "bin")
+	if err := os.MkdirAll(binDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", binDir)
+	if _, err := systemBinariesPath(); !errors.Is(err, ErrBinariesNotFound) {
+		t.Errorf("systemBinariesPath() error = %v, want ErrBinariesNotFound", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(binDir, executable("pg_ctl")), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := systemBinariesPath()
+	if err != nil {
+		t.Fatalf("systemBinariesPath() failed: %v", err)
+	}
+	if want, _ := filepath.EvalSymlinks(installDir); got != want {
+		t.Errorf("systemBinariesPath() = %q, want %q", got, want)
+	}
+}
+
+func TestNewOfflineWithoutCachedBinaries(t *testing.T) {
+	_, err := New(Config{
+		Version: "0.0.1", // never downloaded
+		Offline: true,
+	})
+	if !errors.Is(err, ErrBinariesNotFound) {
+		t.Fatalf("New() error = %v, want ErrBinariesNotFound", err)
+	}
+}
+
+// releaseArchive builds a minimal postgresql-binaries style .tar.gz archive.
+func releaseArchive(t *testing.T, version string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	root := "postgresql-" + version + "-test/"
+	files := map[string]string{
+		root + "bin/" + executable("pg_ctl"): "#!/bin/sh\n",
+		root + "share/README":                "test archive\n",
+	}
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("WriteHeader(%s) failed: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("Write(%s) failed: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer failed: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("closing gzip writer failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	tests := []struct {
+		links []tar.Header
+		valid bool
+	}{
+		{[]tar.Header{{Name: "root/lib/libpq.so", Linkname: "libpq.so.5"}}, true},
+		{[]tar.Header{{Name: "root/lib/libpq.so", Linkname: "../share/libpq.so"}}, true},
+		{[]tar.Header{{Name: "root/lib/escape", Linkname: "/etc"}}, false},
+		{[]tar.Header{{Name: "root/lib/escape", Linkname: "../../outside"}}, false},
+		// Lexically in the archive, but lib is a link to its root.
+		{[]tar.Header{{Name: "root/lib", Linkname: "."}, {Name: "root/lib/escape", Linkname: "../outside"}}, false},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, link := range tt.links {
+			link.Typeflag = tar.TypeSymlink
+			if err := tw.WriteHeader(&link); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		gz.Close()
+
+		dir := t.TempDir()
+		err := extractArchive(&buf, dir)
+		if tt.valid && err != nil {
+			t.Errorf("extractArchive(%v) failed: %v", tt.links, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("extractArchive(%v) succeeded, want an error", tt.links)
+		}
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	target, err := platformTarget()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct{ baseURL, want string }{
+		{"", defaultDownloadBaseURL + "/16.0.0/postgresql-16.0.0-" + target + ".tar.gz"},
+		{"https://mirror.example.com/pg/", "https://mirror.example.com/pg/16.0.0/postgresql-16.0.0-" + target + ".tar.gz"},
+		{"https://mirror.example.com/pg-{version}-{target}.tgz", "https://mirror.example.com/pg-16.0.0-" + target + ".tgz"},
+	}
+	for _, tt := range tests {
+		got, err := downloadURL(tt.baseURL, "16.0.0")
+		if err != nil {
+			t.Errorf("downloadURL(%q) failed: %v", tt.baseURL, err)
+		}
+		if got != tt.want {
+			t.Errorf("downloadURL(%q) = %q, want %q", tt.baseURL, got, tt.want)
+		}
+	}
+}
+
+func TestInstallBinariesFromMirror(t *testing.T) {
+	archive := releaseArchive(t, "16.0.0")
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/16.0.0.tar.gz":
+			w.Write(archive)
+		case "/16.0.0.tar.gz.sha256":
+			sum := sha256.Sum256(archive)
+			fmt.Fprintf(w, "%x  16.0.0.tar.gz\n", sum)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	if err := installBinaries(context.Background(), mirror.URL+"/16.0.0.tar.gz", cacheDir, "16.0.0", nil); err != nil {
+		t.Fatalf("installBinaries() failed: %v", err)
+	}
+	if !hasBinaries(filepath.Join(cacheDir, "16.0.0")) {
+		t.Error("binaries were not installed into the cache directory")
+	}
+
+	err := installBinaries(context.Background(), mirror.URL+"/15.0.0.tar.gz", cacheDir, "15.0.0", nil)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("installBinaries() of a missing version error = %v, want a 404 error", err)
+	}
+}
+
+func TestEnsureBinariesCacheHit(t *testing.T) {
+	if _, err := platformTarget(); err != nil {
+		t.Skip(err)
+	}
+	archive := releaseArchive(t, "16.0.0")
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/16.0.0.tar.gz":
+			w.Write(archive)
+		case "/16.0.0.tar.gz.sha256":
+			sum := sha256.Sum256(archive)
+			fmt.Fprintf(w, "%x  16.0.0.tar.gz\n", sum)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	config := Config{Version: "16.0.0", CacheDir: t.TempDir(), DownloadBaseURL: mirror.URL + "/{version}.tar.gz"}
+	for _, want := range []bool{false, true} {
+		install, err := ensureBinaries(config)
+		if err != nil {
+			t.Fatalf("ensureBinaries() failed: %v", err)
+		}
+		if install.cached != want {
+			t.Errorf("ensureBinaries().cached = %v, want %v", install.cached, want)
+		}
+		install.release()
+	}
+}
+
+func TestConcurrentInstallDownloadsOnce(t *testing.T) {
+	archive := releaseArchive(t, "16.0.0")
+	var downloads int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Write(archive)
+	}))
+	defer mirror.Close()
+
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- installBinaries(context.Background(), mirror.URL+"/16.0.0.tar.gz", cacheDir, "16.0.0", nil)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("installBinaries() failed: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("archive was downloaded %d times, want 1", n)
+	}
+}
+
+func TestNewWithSharedCacheDir(t *testing.T) {
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		dataDir := filepath.Join(cacheDir, fmt.Sprintf("data%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pg, err := New(Config{
+				Version:    DefaultVersion,
+				CacheDir:   cacheDir,
+				DataDir:    dataDir,
+				RuntimeDir: dataDir,
+			})
+			if err != nil {
+				t.Errorf("New() with a shared CacheDir failed: %v", err)
+				return
+			}
+			if err := pg.Stop(); err != nil {
+				t.Errorf("Stop() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !hasBinaries(filepath.Join(cacheDir, "16.0.0")) {
+		t.Error("binaries were not installed into the shared CacheDir")
+	}
+}
+
+func TestInstallBinariesReportsProgress(t *testing.T) {
+	archive := releaseArchive(t, "16.0.0")
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer mirror.Close()
+
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+
+	var calls int
+	var lastDownloaded, lastTotal int64
+	progress := func(downloaded, total int64) {
+		calls++
+		lastDownloaded, lastTotal = downloaded, total
+	}
+	if err := installBinaries(context.Background(), mirror.URL+"/16.0.0.tar.gz", cacheDir, "16.0.0", progress); err != nil {
+		t.Fatalf("installBinaries() failed: %v", err)
+	}
+	if calls == 0 {
+		t.Fatal("progress callback was never called")
+	}
+	if lastDownloaded != int64(len(archive)) || lastTotal != int64(len(archive)) {
+		t.Errorf("last progress = (%d, %d), want (%d, %d)", lastDownloaded, lastTotal, len(archive), len(archive))
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never respond.
+	}))
+	defer mirror.Close()
+
+	cacheDir := t.TempDir()
+	_, err := ensureBinaries(Config{
+		Version:         "16.0.0",
+		CacheDir:        cacheDir,
+		DownloadBaseURL: mirror.URL + "/{version}.tar.gz",
+		DownloadTimeout: 100 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrDownloadTimeout) {
+		t.Errorf("ensureBinaries() = %v, want ErrDownloadTimeout", err)
+	}
+}
+
+func TestDownloadTimeoutWaitingForLock(t *testing.T) {
+	cacheDir := t.TempDir()
+	// A live install by another process holds the lock.
+	if err := os.WriteFile(filepath.Join(cacheDir, "16.0.0.lock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ensureBinaries(Config{
+		Version:         "16.0.0",
+		CacheDir:        cacheDir,
+		DownloadBaseURL: "http://127.0.0.1:1/{version}.tar.gz",
+		DownloadTimeout: 300 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrDownloadTimeout) {
+		t.Errorf("ensureBinaries() = %v, want ErrDownloadTimeout", err)
+	}
+
+	// The lock of this process is free again.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	os.Remove(filepath.Join(cacheDir, "16.0.0.lock"))
+	unlock, err := lockInstall(ctx, filepath.Join(cacheDir, "16.0.0"))
+	if err != nil {
+		t.Fatalf("lockInstall() after a timeout failed: %v", err)
+	}
+	unlock()
+}
+
+func TestBinariesVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pg_ctl is a shell script")
+	}
+	for _, tt := range []struct{ output, want string }{
+		{"pg_ctl (PostgreSQL) 16.4", "16.4.0"},
+		{"pg_ctl (PostgreSQL) 16.4 (Ubuntu 16.4-1.pgdg22.04+1)", "16.4.0"},
+		{"pg_ctl (PostgreSQL) 9.6.24", "9.6.24"},
+		{"pg_ctl (PostgreSQL) 17devel", "17.0.0"},
+	} {
+		binDir := t.TempDir()
+		script := "#!/bin/sh\necho '" + tt.output + "'\n"
+		if err := os.WriteFile(filepath.Join(binDir, "pg_ctl"), []byte(script), 0750); err != nil {
+			t.Fatal(err)
+		}
+		got, err := binariesVersion(binDir)
+		if err != nil {
+			t.Errorf("binariesVersion() of %q failed: %v", tt.output, err)
+		} else if got != tt.want {
+			t.Errorf("binariesVersion() of %q = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+
+	if _, err := binariesVersion(t.TempDir()); err == nil {
+		t.Error("binariesVersion() without pg_ctl succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+package pgembed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrBinariesInUse is returned by PurgeCache and PurgeAllCache for cached binaries
+// used by a running instance.
+var ErrBinariesInUse = errors.New("PostgreSQL binaries are in use")
+
+// binariesInUse counts the running instances of this process by the directory of the
+// binaries they use, the parent of their bin directory.
+var binariesInUse = struct {
+	sync.Mutex
+	dirs map[string]int
+}{dirs: map[string]int{}}
+
+// useBinaries marks the binaries in dir as used until the returned function, which may
+// be called more than once, is called.
+func useBinaries(dir string) func() {
+	binariesInUse.Lock()
+	binariesInUse.dirs[dir]++
+	binariesInUse.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			binariesInUse.Lock()
+			defer binariesInUse.Unlock()
+			if binariesInUse.dirs[dir]--; binariesInUse.dirs[dir] <= 0 {
+				delete(binariesInUse.dirs, dir)
+			}
+		})
+	}
+}
+
+// PurgeCache removes the binaries of version from cacheDir, or the default cache
+// directory if it is empty, and returns the number of bytes freed. It fails with
+// ErrBinariesInUse if an instance of this process is running them; instances of other
+// processes can't be detected. Purging a version that isn't cached frees 0 bytes.
+func PurgeCache(cacheDir, version string) (int64, error) {
+	if !exactVersion.MatchString(version) {
+		return 0, fmt.Errorf("invalid version %q: must be an exact version such as %q", version, DefaultVersion)
+	}
+	cacheDir, err := cacheDirectory(Config{CacheDir: cacheDir})
+	if err != nil {
+		return 0, err
+	}
+	return purgeVersion(cacheDir, version)
+}
+
+// PurgeAllCache removes the binaries of every version from cacheDir, or the default
+// cache directory if it is empty, and returns the number of bytes freed. Versions used
+// by a running instance of this process are kept and reported with ErrBinariesInUse.
+func PurgeAllCache(cacheDir string) (int64, error) {
+	cacheDir, err := cacheDirectory(Config{CacheDir: cacheDir})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache directory %s: %w", cacheDir, err)
+	}
+
+	var freed int64
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !exactVersion.MatchString(entry.Name()) {
+			continue
+		}
+		n, err := purgeVersion(cacheDir, entry.Name())
+		freed += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return freed, errors.Join(errs...)
+}
+
+// purgeVersion removes cacheDir/version, holding its install lock so that it isn't
+// installed meanwhile.
+func purgeVersion(cacheDir, version string) (int64, error) {
+	versionDir := filepath.Join(cacheDir, version)
+	if _, err := os.Stat(versionDir); errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	unlock, err := lockInstall(context.Background(), versionDir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	binariesInUse.Lock()
+	defer binariesInUse.Unlock()
+	if binariesInUse.dirs[versionDir] > 0 {
+		return 0, fmt.Errorf("%w: version %s in %s is used by a running instance", ErrBinariesInUse, version, cacheDir)
+	}
+
+	size, err := dirSize(versionDir)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(versionDir); err != nil {
+		// Part of the files may have been removed already.
+		remaining, _ := dirSize(versionDir)
+		return size - remaining, fmt.Errorf("failed to remove %s: %w", versionDir, err)
+	}
+	return size, nil
+}
+
+// dirSize returns the total size of the files in dir, 0 if it doesn't exist.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", dir, err)
+	}
+	return size, nil
+}
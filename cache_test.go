@@ -0,0 +1,125 @@
+package pgembed
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeBinaries creates a fake installation of version in cacheDir, holding size bytes.
+func fakeBinaries(t *testing.T, cacheDir, version string, size int) {
+	t.Helper()
+	binDir := filepath.Join(cacheDir, version, "bin")
+	if err := os.MkdirAll(binDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(binDir, executable("pg_ctl")), make([]byte, size), 0750); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedVersions(t *testing.T) {
+	cacheDir := t.TempDir()
+	fakeBinaries(t, cacheDir, "16.0.0", 0)
+	fakeBinaries(t, cacheDir, "9.6.0", 0)
+	fakeBinaries(t, cacheDir, "16.10.0", 0)
+	// Not installed versions: an empty version directory and an install lock.
+	if err := os.MkdirAll(filepath.Join(cacheDir, "15.0.0"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "16.0.0.lock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := CachedVersions(cacheDir)
+	if err != nil {
+		t.Fatalf("CachedVersions() failed: %v", err)
+	}
+	if want := []string{"9.6.0", "16.0.0", "16.10.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("CachedVersions() = %v, want %v", versions, want)
+	}
+
+	if versions, err := CachedVersions(filepath.Join(cacheDir, "missing")); err != nil || len(versions) != 0 {
+		t.Errorf("CachedVersions() of a missing directory = %v, %v, want none", versions, err)
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	fakeBinaries(t, cacheDir, "15.0.0", 100)
+	fakeBinaries(t, cacheDir, "16.0.0", 200)
+
+	freed, err := PurgeCache(cacheDir, "15.0.0")
+	if err != nil {
+		t.Fatalf("PurgeCache() failed: %v", err)
+	}
+	if freed != 100 {
+		t.Errorf("PurgeCache() = %d, want 100", freed)
+	}
+	if hasBinaries(filepath.Join(cacheDir, "15.0.0")) {
+		t.Error("PurgeCache() kept the binaries of 15.0.0")
+	}
+	if !hasBinaries(filepath.Join(cacheDir, "16.0.0")) {
+		t.Error("PurgeCache() removed the binaries of 16.0.0")
+	}
+
+	if freed, err := PurgeCache(cacheDir, "15.0.0"); err != nil || freed != 0 {
+		t.Errorf("PurgeCache() of a purged version = %d, %v, want 0, nil", freed, err)
+	}
+	if _, err := PurgeCache(cacheDir, "../16.0.0"); err == nil {
+		t.Error("PurgeCache() with an invalid version succeeded")
+	}
+}
+
+func TestPurgeCacheInUse(t *testing.T) {
+	cacheDir := t.TempDir()
+	fakeBinaries(t, cacheDir, "15.0.0", 100)
+	fakeBinaries(t, cacheDir, "16.0.0", 200)
+
+	release := useBinaries(filepath.Join(cacheDir, "16.0.0"))
+	if _, err := PurgeCache(cacheDir, "16.0.0"); !errors.Is(err, ErrBinariesInUse) {
+		t.Errorf("PurgeCache() of binaries in use = %v, want ErrBinariesInUse", err)
+	}
+	freed, err := PurgeAllCache(cacheDir)
+	if !errors.Is(err, ErrBinariesInUse) {
+		t.Errorf("PurgeAllCache() with binaries in use = %v, want ErrBinariesInUse", err)
+	}
+	if freed != 100 {
+		t.Errorf("PurgeAllCache() = %d, want 100", freed)
+	}
+	if !hasBinaries(filepath.Join(cacheDir, "16.0.0")) {
+		t.Error("PurgeAllCache() removed the binaries in use")
+	}
+
+	release()
+	release() // No-op.
+	if freed, err := PurgeAllCache(cacheDir); err != nil || freed != 200 {
+		t.Errorf("PurgeAllCache() after release = %d, %v, want 200, nil", freed, err)
+	}
+	if versions, err := cachedVersions(cacheDir); err != nil || len(versions) != 0 {
+		t.Errorf("cachedVersions() after PurgeAllCache() = %v, %v, want none", versions, err)
+	}
+}
+
+func TestEnsureBinariesMarksThemUsed(t *testing.T) {
+	cacheDir := t.TempDir()
+	fakeBinaries(t, cacheDir, "16.0.0", 100)
+
+	install, err := ensureBinaries(Config{Version: "16.0.0", CacheDir: cacheDir, Offline: true})
+	if err != nil {
+		t.Fatalf("ensureBinaries() failed: %v", err)
+	}
+	if _, err := PurgeCache(cacheDir, "16.0.0"); !errors.Is(err, ErrBinariesInUse) {
+		t.Errorf("PurgeCache() of binaries returned by ensureBinaries() = %v, want ErrBinariesInUse", err)
+	}
+
+	install.release()
+	if freed, err := PurgeCache(cacheDir, "16.0.0"); err != nil || freed != 100 {
+		t.Errorf("PurgeCache() after release = %d, %v, want 100, nil", freed, err)
+	}
+	if _, err := ensureBinaries(Config{Version: "16.0.0", CacheDir: cacheDir, Offline: true}); !errors.Is(err, ErrBinariesNotFound) {
+		t.Errorf("ensureBinaries() of purged binaries = %v, want ErrBinariesNotFound", err)
+	}
+}
@@ -0,0 +1,154 @@
+package pgembed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFields has the fields of Config without its methods, see ConfigFromJSON.
+type configFields Config
+
+// jsonDuration is a time.Duration read from JSON as a string accepted by
+// time.ParseDuration, e.g. "30s", or as a number of nanoseconds.
+type jsonDuration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(data, &ns); err != nil {
+			return fmt.Errorf("invalid duration %s: expected a string such as \"30s\" or nanoseconds", data)
+		}
+		*d = jsonDuration(ns)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// ConfigFromJSON parses a Config from JSON and validates it. The keys are the names of
+// the fields of Config, matched case-insensitively, e.g. {"version": "16", "port": 5433}.
+// Durations are strings such as "30s". Fields that are functions can't be set.
+func ConfigFromJSON(data []byte) (Config, error) {
+	var config Config
+	// The duration fields of Config are shadowed by ones parsing durations, and copied
+	// over once parsed.
+	var parsed struct {
+		*configFields
+		DownloadTimeout  jsonDuration
+		StartupTimeout   jsonDuration
+		ConnectTimeout   jsonDuration
+		StatementTimeout jsonDuration
+	}
+	parsed.configFields = (*configFields)(&config)
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return Config{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+	config.DownloadTimeout = time.Duration(parsed.DownloadTimeout)
+	config.StartupTimeout = time.Duration(parsed.StartupTimeout)
+	config.ConnectTimeout = time.Duration(parsed.ConnectTimeout)
+	config.StatementTimeout = time.Duration(parsed.StatementTimeout)
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// ConfigFromFile reads a Config from a JSON file, or a YAML one if its extension is
+// .yaml or .yml, see ConfigFromJSON. YAML uses the same keys as JSON.
+func ConfigFromFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		// Converted to JSON so that both formats are parsed the same way.
+		var values map[string]any
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+		if data, err = json.Marshal(values); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	default:
+		return Config{}, fmt.Errorf("unsupported config file %s: expected a .json, .yaml or .yml extension", path)
+	}
+	config, err := ConfigFromJSON(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// WithEnvOverrides returns c with the fields for which an environment variable is set
+// overridden by its value, e.g. to change the configuration in CI:
+//
+//	PGEMBED_VERSION, PGEMBED_PORT, PGEMBED_HOST, PGEMBED_DATA_DIR, PGEMBED_RUNTIME_DIR,
+//	PGEMBED_SUPERUSER_NAME, PGEMBED_PASSWORD, PGEMBED_BINARIES_PATH, PGEMBED_CACHE_DIR,
+//	PGEMBED_DOWNLOAD_BASE_URL, PGEMBED_OFFLINE, PGEMBED_DOWNLOAD_TIMEOUT and
+//	PGEMBED_STARTUP_TIMEOUT.
+//
+// Empty variables are ignored. Booleans are parsed by strconv.ParseBool and durations
+// by time.ParseDuration; an invalid value is an error.
+func (c Config) WithEnvOverrides() (Config, error) {
+	for name, field := range map[string]*string{
+		"PGEMBED_VERSION":           &c.Version,
+		"PGEMBED_HOST":              &c.Host,
+		"PGEMBED_DATA_DIR":          &c.DataDir,
+		"PGEMBED_RUNTIME_DIR":       &c.RuntimeDir,
+		"PGEMBED_SUPERUSER_NAME":    &c.SuperuserName,
+		"PGEMBED_PASSWORD":          &c.Password,
+		"PGEMBED_BINARIES_PATH":     &c.BinariesPath,
+		"PGEMBED_CACHE_DIR":         &c.CacheDir,
+		"PGEMBED_DOWNLOAD_BASE_URL": &c.DownloadBaseURL,
+	} {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+	if value := os.Getenv("PGEMBED_PORT"); value != "" {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid PGEMBED_PORT %q: expected a port number", value)
+		}
+		c.Port = uint16(port)
+	}
+	if value := os.Getenv("PGEMBED_OFFLINE"); value != "" {
+		offline, err := strconv.ParseBool(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid PGEMBED_OFFLINE %q: expected true or false", value)
+		}
+		c.Offline = offline
+	}
+	for name, field := range map[string]*time.Duration{
+		"PGEMBED_DOWNLOAD_TIMEOUT": &c.DownloadTimeout,
+		"PGEMBED_STARTUP_TIMEOUT":  &c.StartupTimeout,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: expected a duration such as \"30s\"", name, value)
+			}
+			*field = d
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,104 @@
+package pgembed
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromJSON(t *testing.T) {
+	config, err := ConfigFromJSON([]byte(`{
+		"version": "16",
+		"port": 5433,
+		"startupTimeout": "45s",
+		"hbaRules": ["host all all 10.0.0.0/8 scram-sha-256"],
+		"databases": [{"name": "app", "owner": "postgres"}]
+	}`))
+	if err != nil {
+		t.Fatalf("ConfigFromJSON() failed: %v", err)
+	}
+	want := Config{
+		Version:        "16",
+		Port:           5433,
+		StartupTimeout: 45 * time.Second,
+		HBARules:       []string{"host all all 10.0.0.0/8 scram-sha-256"},
+		Databases:      []DatabaseSpec{{Name: "app", Owner: "postgres"}},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("ConfigFromJSON() = %+v, want %+v", config, want)
+	}
+
+	for _, invalid := range []string{
+		`{"version": "16", "prot": 5433}`,
+		`{"version": "16", "startupTimeout": "soon"}`,
+		`{"version": "16.x"}`,
+	} {
+		if _, err := ConfigFromJSON([]byte(invalid)); err == nil {
+			t.Errorf("ConfigFromJSON(%s) succeeded", invalid)
+		}
+	}
+}
+
+func TestConfigFromFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pgembed.json": `{"version": "16", "port": 5433, "downloadTimeout": "1m"}`,
+		"pgembed.yaml": "version: \"16\"\nport: 5433\ndownloadTimeout: 1m\n",
+		"pgembed.yml":  "Version: \"16\"\nPort: 5433\nDownloadTimeout: 1m\n",
+	}
+	want := Config{Version: "16", Port: 5433, DownloadTimeout: time.Minute}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := ConfigFromFile(path)
+		if err != nil {
+			t.Errorf("ConfigFromFile(%s) failed: %v", name, err)
+		} else if !reflect.DeepEqual(config, want) {
+			t.Errorf("ConfigFromFile(%s) = %+v, want %+v", name, config, want)
+		}
+	}
+
+	path := filepath.Join(dir, "pgembed.toml")
+	if err := os.WriteFile(path, []byte(`version = "16"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConfigFromFile(path); err == nil || !strings.Contains(err.Error(), "extension") {
+		t.Errorf("ConfigFromFile(%s) error = %v, want an unsupported extension", path, err)
+	}
+}
+
+func TestWithEnvOverrides(t *testing.T) {
+	t.Setenv("PGEMBED_VERSION", "15")
+	t.Setenv("PGEMBED_PORT", "5444")
+	t.Setenv("PGEMBED_DATA_DIR", "/var/lib/pgembed")
+	t.Setenv("PGEMBED_OFFLINE", "true")
+	t.Setenv("PGEMBED_STARTUP_TIMEOUT", "2m")
+	t.Setenv("PGEMBED_CACHE_DIR", "")
+
+	config, err := Config{Version: "16", CacheDir: "/cache", Password: "secret"}.WithEnvOverrides()
+	if err != nil {
+		t.Fatalf("WithEnvOverrides() failed: %v", err)
+	}
+	want := Config{
+		Version:        "15",
+		Port:           5444,
+		DataDir:        "/var/lib/pgembed",
+		Offline:        true,
+		StartupTimeout: 2 * time.Minute,
+		CacheDir:       "/cache",
+		Password:       "secret",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("WithEnvOverrides() = %+v, want %+v", config, want)
+	}
+
+	t.Setenv("PGEMBED_PORT", "fifty")
+	if _, err := (Config{}).WithEnvOverrides(); err == nil || !strings.Contains(err.Error(), "PGEMBED_PORT") {
+		t.Errorf("WithEnvOverrides() error = %v, want an invalid PGEMBED_PORT", err)
+	}
+}
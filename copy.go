@@ -0,0 +1,92 @@
+package pgembed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// CopyOptions controls the CSV format of CopyFromCSV and CopyToCSV.
+type CopyOptions struct {
+	// Header reports whether the first line holds the column names rather than data.
+	// CopyToCSV then writes one.
+	Header bool
+	// Delimiter separates the columns. Defaults to ",".
+	Delimiter string
+	// Null is the string representing NULL values. Defaults to an unquoted empty string.
+	Null string
+}
+
+// sql returns the options of the COPY statement.
+func (o CopyOptions) sql() string {
+	options := []string{"FORMAT csv"}
+	if o.Header {
+		options = append(options, "HEADER true")
+	}
+	if o.Delimiter != "" {
+		options = append(options, "DELIMITER "+pq.QuoteLiteral(o.Delimiter))
+	}
+	if o.Null != "" {
+		options = append(options, "NULL "+pq.QuoteLiteral(o.Null))
+	}
+	return "(" + strings.Join(options, ", ") + ")"
+}
+
+// CopyFromCSV loads the rows of csv into table, which may be schema qualified, in the
+// database dbName, with COPY. It returns the number of rows loaded. This is much faster
+// than inserting the rows one by one.
+func (pg *EmbeddedPostgres) CopyFromCSV(dbName, table string, csv io.Reader, opts CopyOptions) (int64, error) {
+	if table == "" {
+		return 0, errors.New("table name cannot be empty")
+	}
+	dsn, err := pg.toolDSN(dbName)
+	if err != nil {
+		return 0, err
+	}
+
+	// psql forwards its standard input to COPY ... FROM STDIN.
+	query := "COPY " + quoteQualifiedName(table) + " FROM STDIN WITH " + opts.sql()
+	var stdout bytes.Buffer
+	if err := pg.runToolIO(csv, &stdout, "psql", "--dbname="+dsn, "--no-psqlrc", "--set=ON_ERROR_STOP=1", "--command="+query); err != nil {
+		return 0, err
+	}
+
+	tag := strings.TrimSpace(stdout.String())
+	rows, err := strconv.ParseInt(strings.TrimPrefix(tag, "COPY "), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected COPY result %q", tag)
+	}
+	return rows, nil
+}
+
+// CopyToCSV runs query in the database dbName and writes the resulting rows to w as
+// CSV, with COPY, e.g. to snapshot the contents of a table into a golden file:
+//
+//	err := pg.CopyToCSV("app", "SELECT * FROM users ORDER BY id", f, pgembed.CopyOptions{Header: true})
+func (pg *EmbeddedPostgres) CopyToCSV(dbName, query string, w io.Writer, opts CopyOptions) error {
+	if query == "" {
+		return errors.New("query cannot be empty")
+	}
+	dsn, err := pg.toolDSN(dbName)
+	if err != nil {
+		return err
+	}
+
+	// --quiet keeps psql from writing the COPY command tag after the rows.
+	copyQuery := "COPY (" + query + ") TO STDOUT WITH " + opts.sql()
+	return pg.runToolIO(nil, w, "psql", "--dbname="+dsn, "--no-psqlrc", "--quiet", "--set=ON_ERROR_STOP=1", "--command="+copyQuery)
+}
+
+// quoteQualifiedName quotes each part of a possibly schema qualified name.
+func quoteQualifiedName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
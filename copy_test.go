@@ -0,0 +1,88 @@
+package pgembed
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCopyOptions(t *testing.T) {
+	tests := []struct {
+		opts CopyOptions
+		want string
+	}{
+		{CopyOptions{}, "(FORMAT csv)"},
+		{CopyOptions{Header: true, Delimiter: ";", Null: "NULL"}, "(FORMAT csv, HEADER true, DELIMITER ';', NULL 'NULL')"},
+		{CopyOptions{Delimiter: "'"}, "(FORMAT csv, DELIMITER '''')"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.sql(); got != tt.want {
+			t.Errorf("%+v.sql() = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestCopyFromCSV(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE TABLE fruits (name text, color text)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	csv := "name;color\napple;red\n\"banana; ripe\";yellow\nkiwi;-\n"
+	rows, err := pg.CopyFromCSV("", "public.fruits", strings.NewReader(csv), CopyOptions{Header: true, Delimiter: ";", Null: "-"})
+	if err != nil {
+		t.Fatalf("CopyFromCSV() failed: %v", err)
+	}
+	if rows != 3 {
+		t.Errorf("CopyFromCSV() = %d rows, want 3", rows)
+	}
+
+	var nulls int
+	if err := pg.QueryRow("", "SELECT count(*) FROM fruits WHERE color IS NULL").Scan(&nulls); err != nil {
+		t.Fatal(err)
+	}
+	if nulls != 1 {
+		t.Errorf("got %d NULL colors, want 1", nulls)
+	}
+
+	if _, err := pg.CopyFromCSV("", "fruits", strings.NewReader("only one column\n"), CopyOptions{}); err == nil {
+		t.Error("CopyFromCSV() with invalid rows succeeded")
+	}
+}
+
+func TestCopyToCSV(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	setup := "CREATE TABLE fruits (name text, color text); INSERT INTO fruits VALUES ('apple', 'red'), ('kiwi', NULL)"
+	if err := pg.Exec("", setup); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	var out strings.Builder
+	err = pg.CopyToCSV("", "SELECT * FROM fruits ORDER BY name", &out, CopyOptions{Header: true, Delimiter: ";", Null: "-"})
+	if err != nil {
+		t.Fatalf("CopyToCSV() failed: %v", err)
+	}
+	want := "name;color\napple;red\nkiwi;-\n"
+	if out.String() != want {
+		t.Errorf("CopyToCSV() wrote %q, want %q", out.String(), want)
+	}
+
+	if err := pg.CopyToCSV("", "SELECT * FROM missing", &out, CopyOptions{}); err == nil {
+		t.Error("CopyToCSV() of an invalid query succeeded")
+	}
+}
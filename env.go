@@ -0,0 +1,52 @@
+package pgembed
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WriteEnvFile writes the libpq environment variables PGHOST, PGPORT, PGUSER, PGPASSWORD
+// and PGDATABASE, and DATABASE_URL, to connect to the database dbName as the superuser
+// into the dotenv file at path, e.g. for processes other than Go tests.
+// If dbName is empty, the "postgres" maintenance database is used.
+// The file is only readable by the current user, as it holds the password.
+func (pg *EmbeddedPostgres) WriteEnvFile(dbName, path string) error {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return err
+	}
+	dbURL, err := pg.URL(dbName)
+	if err != nil {
+		return err
+	}
+
+	vars := [][2]string{
+		{"PGHOST", params.Host},
+		{"PGPORT", strconv.Itoa(int(params.Port))},
+		{"PGUSER", params.User},
+		{"PGPASSWORD", params.Password},
+		{"PGDATABASE", params.Database},
+		{"DATABASE_URL", dbURL},
+	}
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=%s\n", v[0], quoteEnvValue(v[1]))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	return nil
+}
+
+// quoteEnvValue quotes a dotenv value. Single quoted values are taken literally by
+// dotenv implementations; values holding a single quote, or a line break, are double
+// quoted with backslash escapes.
+func quoteEnvValue(value string) string {
+	if !strings.ContainsAny(value, "'\r\n") {
+		return "'" + value + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
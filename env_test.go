@@ -0,0 +1,56 @@
+package pgembed
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteEnvFile(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, Password: "it's secret"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := pg.WriteEnvFile("", path); err != nil {
+		t.Fatalf("WriteEnvFile() failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"PGHOST='localhost'\n",
+		"PGUSER='postgres'\n",
+		`PGPASSWORD="it's secret"` + "\n",
+		"PGDATABASE='postgres'\n",
+		"DATABASE_URL='postgres://",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("env file = %q, want it to contain %q", content, want)
+		}
+	}
+}
+
+func TestQuoteEnvValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", "''"},
+		{"p@ss $word", "'p@ss $word'"},
+		{"it's", `"it's"`},
+		{`it's "$x" \`, `"it's \"\$x\" \\"`},
+		{"a\nb", `"a\nb"`},
+	}
+	for _, tt := range tests {
+		if got := quoteEnvValue(tt.value); got != tt.want {
+			t.Errorf("quoteEnvValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
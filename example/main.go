@@ -11,7 +11,7 @@ import (
 
 func main() {
 	pg, err := pgembed.New(pgembed.Config{
-		Version:    "16.0.0",
+		Version:    pgembed.DefaultVersion,
 		DataDir:    ".postgresql",
 		RuntimeDir: ".postgresql",
 	})
@@ -25,7 +25,7 @@ func main() {
 		}
 	}()
 
-	err = pg.CreateDatabase("lanzadm", "lanzadm")
+	err = pg.CreateDatabase("lanzadm", "")
 	if err != nil {
 		log.Fatalf("failed to create db instance: %v", err)
 	}
@@ -0,0 +1,175 @@
+//go:build cgo && ((darwin && arm64) || (linux && amd64 && !musl))
+
+package pgembed
+
+/*
+// The prebuilt Rust libraries ship with the module in libs/<platform>. ${SRCDIR} locates
+// them relative to this package, so building works without a pre-step, also when the
+// package is a dependency in the module cache. Rebuild them with:
+//
+//     go generate
+//
+// Only darwin/arm64 and linux/amd64 have a prebuilt library for now. Other platforms,
+// including musl (-tags musl), are built as without cgo, see ffi_nocgo.go.
+//
+
+// Common linker flags needed by Rust standard library and dependencies.
+// Adjust if your Rust code has other specific system dependencies.
+#cgo darwin,arm64 LDFLAGS: -L${SRCDIR}/libs/darwin_arm64 -lgo_pgembed_lib -ldl -lm -framework Security -framework CoreFoundation -framework SystemConfiguration -llzma -mmacosx-version-min=15.1
+#cgo linux,amd64,!musl LDFLAGS: -L${SRCDIR}/libs/linux_amd64 -lgo_pgembed_lib -ldl -lm -lrt -lpthread -llzma
+
+// C function declarations matching the Rust FFI.
+// Using `typedef struct RustEmbeddedPg RustEmbeddedPg;` for the opaque pointer.
+#include <stdlib.h> // For C.free
+#include <stdbool.h> // For C._Bool (Go bool)
+#include <stdint.h> // For C.uint64_t
+
+typedef struct RustEmbeddedPg RustEmbeddedPg; // Opaque struct
+
+// Define the result struct to match Rust's PgStartResult
+typedef struct {
+    RustEmbeddedPg* pg_ptr;
+    char* error_msg;
+} PgStartResult;
+
+PgStartResult pg_embedded_create_and_start(
+    const char* data_dir_str,
+    const char* runtime_dir_str,
+    unsigned short port,
+    const char* password_str,
+    const char* options_str
+);
+
+bool pg_embedded_stop(RustEmbeddedPg* pg_ptr);
+
+char* pg_embedded_get_connection_string(const RustEmbeddedPg* pg_ptr, const char* db_name_str);
+
+bool pg_embedded_create_database(RustEmbeddedPg* pg_ptr, const char* db_name_str);
+
+bool pg_embedded_drop_database(RustEmbeddedPg* pg_ptr, const char* db_name_str);
+
+void pg_embedded_free_string(char* s);
+
+// Test-only entry points.
+char* pg_embedded_test_panic(void);
+void pg_embedded_test_string_counts(uint64_t* allocated, uint64_t* freed);
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// cgoEnabled reports whether the server is managed by the Rust layer, which downloads
+// the binaries itself. Without cgo, see ffi_nocgo.go.
+const cgoEnabled = true
+
+// serverInstance is the handle of a server started by the Rust layer.
+type serverInstance = *C.RustEmbeddedPg
+
+// start creates and starts the server through the Rust layer.
+func start(dataDir, runtimeDir string, port uint16, password, options string) (serverInstance, error) {
+	cDataDir := C.CString(dataDir)
+	defer C.free(unsafe.Pointer(cDataDir))
+
+	var cRuntimeDir *C.char
+	if runtimeDir != "" {
+		cRuntimeDir = C.CString(runtimeDir)
+		defer C.free(unsafe.Pointer(cRuntimeDir))
+	}
+
+	cPassword := C.CString(password)
+	defer C.free(unsafe.Pointer(cPassword))
+
+	cOptions := C.CString(options)
+	defer C.free(unsafe.Pointer(cOptions))
+
+	// Call the modified Rust function which returns PgStartResult struct by value
+	cResult := C.pg_embedded_create_and_start(
+		cDataDir,
+		cRuntimeDir,
+		C.ushort(port),
+		cPassword,
+		cOptions,
+	)
+
+	// Check if Rust returned an error message
+	if cResult.error_msg != nil {
+		errMsg := goStringAndFree(cResult.error_msg)
+
+		// If pg_ptr was somehow non-null, try to stop it (defensive)
+		if cResult.pg_ptr != nil {
+			C.pg_embedded_stop(cResult.pg_ptr)
+		}
+		if strings.Contains(strings.ToLower(errMsg), "already in use") {
+			return nil, fmt.Errorf("%w: failed to create/start embedded PostgreSQL (from Rust): %s", ErrPortInUse, errMsg)
+		}
+		return nil, fmt.Errorf("failed to create/start embedded PostgreSQL (from Rust): %s", errMsg)
+	}
+
+	// If no error message, but pg_ptr is null, this is an unexpected state
+	if cResult.pg_ptr == nil {
+		panic("received null pg_ptr without error message")
+	}
+	return cResult.pg_ptr, nil
+}
+
+// stopServer stops the server and releases the instance, reporting whether the
+// server was stopped.
+func stopServer(instance serverInstance) bool {
+	return bool(C.pg_embedded_stop(instance))
+}
+
+// serverConnectionString returns the connection string to dbName built by the Rust
+// layer.
+func serverConnectionString(instance serverInstance, dbName string) (string, error) {
+	cDbName := C.CString(dbName)
+	defer C.free(unsafe.Pointer(cDbName))
+
+	cConnStr := C.pg_embedded_get_connection_string(instance, cDbName)
+	if cConnStr == nil {
+		return "", errors.New("failed to get connection string (Rust layer returned null)")
+	}
+	return goStringAndFree(cConnStr), nil
+}
+
+// serverDropDatabase drops dbName through the Rust layer.
+func (pg *EmbeddedPostgres) serverDropDatabase(dbName string) error {
+	cDbName := C.CString(dbName)
+	defer C.free(unsafe.Pointer(cDbName))
+
+	if !bool(C.pg_embedded_drop_database(pg.instance, cDbName)) {
+		return fmt.Errorf("failed to drop database '%s'", dbName)
+	}
+	return nil
+}
+
+// goStringAndFree copies a string allocated by the Rust layer and frees it. Every
+// string returned by the Rust layer must be passed to it exactly once, right after
+// the call that returned it, so that no early return can leak it.
+func goStringAndFree(cstr *C.char) string {
+	if cstr == nil {
+		return ""
+	}
+	defer C.pg_embedded_free_string(cstr)
+	return C.GoString(cstr)
+}
+
+// testPanic makes the Rust layer panic, returning the panic as an error, for the tests
+// to check that a panic in the Rust layer doesn't abort the process.
+func testPanic() error {
+	if errMsg := goStringAndFree(C.pg_embedded_test_panic()); errMsg != "" {
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// testStringCounts returns how many strings the Rust layer returned so far, and how
+// many of them were freed, for the tests to check that none leaks.
+func testStringCounts() (allocated, freed uint64) {
+	var cAllocated, cFreed C.uint64_t
+	C.pg_embedded_test_string_counts(&cAllocated, &cFreed)
+	return uint64(cAllocated), uint64(cFreed)
+}
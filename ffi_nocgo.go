@@ -0,0 +1,118 @@
+//go:build !cgo || !((darwin && arm64) || (linux && amd64 && !musl))
+
+package pgembed
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// cgoEnabled reports whether the server is managed by the Rust layer, which downloads
+// the binaries itself. Without cgo, or a prebuilt library for the platform, the server is managed with the pg_ctl of a local
+// PostgreSQL installation instead, by default the one on PATH.
+const cgoEnabled = false
+
+// serverInstance is the handle of a server started with pg_ctl.
+type serverInstance = *systemServer
+
+// systemServer is a server started with pg_ctl.
+type systemServer struct {
+	binDir   string
+	dataDir  string
+	port     uint16
+	username string
+	password string
+}
+
+// start starts the server in the initialized dataDir with pg_ctl, taking the same
+// options as the Rust layer.
+func start(dataDir, runtimeDir string, port uint16, password, options string) (serverInstance, error) {
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options %q: %w", options, err)
+	}
+	if port == 0 {
+		if port, err = freePort(); err != nil {
+			return nil, err
+		}
+	}
+	server := &systemServer{
+		binDir:   filepath.Join(values.Get("installation_dir"), "bin"),
+		dataDir:  dataDir,
+		port:     port,
+		username: values.Get("username"),
+		password: password,
+	}
+
+	// The socket directory is set in the configuration by New.
+	logFile := filepath.Join(dataDir, "server.log")
+	args := []string{"start", "--pgdata=" + dataDir, "--wait", "--log=" + logFile, "--options=" + fmt.Sprintf("-p %d", port)}
+	if timeout := values.Get("timeout"); timeout != "" {
+		args = append(args, "--timeout="+timeout)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(filepath.Join(server.binDir, executable("pg_ctl")), args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// The reason is usually in the server log rather than in the output of pg_ctl.
+		log, _ := os.ReadFile(logFile)
+		errMsg := strings.TrimSpace(stderr.String() + "\n" + string(log))
+		server.stop()
+		if strings.Contains(strings.ToLower(errMsg), "already in use") {
+			return nil, fmt.Errorf("%w: failed to start PostgreSQL with pg_ctl: %s", ErrPortInUse, errMsg)
+		}
+		return nil, fmt.Errorf("failed to start PostgreSQL with pg_ctl: %w: %s", err, errMsg)
+	}
+	return server, nil
+}
+
+// freePort returns a port that is currently free on localhost.
+func freePort() (uint16, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// stop stops the server with pg_ctl in the fast mode, reporting whether it was
+// stopped.
+func (s *systemServer) stop() bool {
+	cmd := exec.Command(filepath.Join(s.binDir, executable("pg_ctl")), "stop", "--pgdata="+s.dataDir, "--mode=fast", "--wait")
+	return cmd.Run() == nil
+}
+
+// stopServer stops the server, reporting whether it was stopped.
+func stopServer(instance serverInstance) bool {
+	return instance.stop()
+}
+
+// serverConnectionString returns the connection string to dbName, in the same form
+// as the Rust layer.
+func serverConnectionString(instance serverInstance, dbName string) (string, error) {
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(instance.username, instance.password),
+		Host:   net.JoinHostPort("localhost", fmt.Sprint(instance.port)),
+		Path:   "/" + dbName,
+	}
+	return u.String(), nil
+}
+
+// serverDropDatabase drops dbName.
+func (pg *EmbeddedPostgres) serverDropDatabase(dbName string) error {
+	if err := pg.Exec("", "DROP DATABASE "+pq.QuoteIdentifier(dbName)); err != nil {
+		return fmt.Errorf("failed to drop database '%s': %w", dbName, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build cgo && ((darwin && arm64) || (linux && amd64 && !musl))
+
+package pgembed
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRustPanicReturnsError(t *testing.T) {
+	err := testPanic()
+	if err == nil || !strings.Contains(err.Error(), "panic in the Rust layer: test panic") {
+		t.Errorf("testPanic() = %v, want the panic as an error", err)
+	}
+	// The process is still alive, and the Rust layer usable.
+	if err := testPanic(); err == nil {
+		t.Error("second testPanic() returned no error")
+	}
+}
+
+func TestRustStringsFreed(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	allocatedBefore, freedBefore := testStringCounts()
+	const calls = 20
+	for i := 0; i < calls; i++ {
+		if _, err := pg.ConnectionString(""); err != nil {
+			t.Fatalf("ConnectionString() failed: %v", err)
+		}
+		if err := pg.CreateDatabase(fmt.Sprintf("strings_%d", i), ""); err != nil {
+			t.Fatalf("CreateDatabase() failed: %v", err)
+		}
+	}
+	allocated, freed := testStringCounts()
+	allocated -= allocatedBefore
+	freed -= freedBefore
+	if allocated < calls {
+		t.Errorf("the Rust layer returned %d strings, want at least %d, one per ConnectionString", allocated, calls)
+	}
+	if freed != allocated {
+		t.Errorf("%d of the %d strings returned by the Rust layer were freed", freed, allocated)
+	}
+}
@@ -27,19 +27,59 @@ if [[ $(uname -s) == "Darwin" ]]; then
     echo "Built for ${TARGET} and copied to ${TARGET_DIR_MACOS_ARM}/"
 fi
 
-# --- Build Linux  ---
+# --- Build Linux x86_64 and arm64 ---
 if [[ $(uname -s) == "Linux" ]]; then
-    TARGET="x86_64-unknown-linux-gnu"
-    TARGET_DIR_LINUX="${OUTPUT_DIR}/linux_amd64"
-    
-    echo "Building ${LIB_NAME} for ${TARGET}..."
-    rustup target add x86_64-unknown-linux-gnu
+    for TARGET in x86_64-unknown-linux-gnu aarch64-unknown-linux-gnu; do
+        case "${TARGET}" in
+            x86_64-*)  ARCH="amd64"; MACHINE="x86_64" ;;
+            aarch64-*) ARCH="arm64"; MACHINE="aarch64" ;;
+        esac
+        TARGET_DIR_LINUX="${OUTPUT_DIR}/linux_${ARCH}"
 
-    (cd "${RUST_LIB_DIR}" && rustup run stable cargo build --release --target "${TARGET}")
-    mkdir -p "${TARGET_DIR_LINUX}"
+        # Cross compiling needs a C compiler for the target, for the C code of the dependencies.
+        if [[ $(uname -m) != "${MACHINE}" ]]; then
+            CROSS_CC="${MACHINE}-linux-gnu-gcc"
+            if ! command -v "${CROSS_CC}" > /dev/null; then
+                echo "Skipping ${TARGET}: ${CROSS_CC} not found."
+                continue
+            fi
+            export "CC_${TARGET//-/_}=${CROSS_CC}"
+        fi
+
+        echo "Building ${LIB_NAME} for ${TARGET}..."
+        rustup target add "${TARGET}"
+
+        (cd "${RUST_LIB_DIR}" && rustup run stable cargo build --release --target "${TARGET}")
+        mkdir -p "${TARGET_DIR_LINUX}"
+
+        cp "${RUST_LIB_DIR}/target/${TARGET}/release/${LIB_NAME}.a" "${TARGET_DIR_LINUX}/"
+        echo "Built for ${TARGET} and copied to ${TARGET_DIR_LINUX}/"
+    done
+fi
+
+# --- Build Linux musl (Alpine), with musl-gcc or natively on Alpine ---
+if [[ $(uname -s) == "Linux" ]]; then
+    TARGET="$(uname -m)-unknown-linux-musl"
+    case "$(uname -m)" in
+        x86_64)  TARGET_DIR_MUSL="${OUTPUT_DIR}/linux_amd64_musl" ;;
+        aarch64) TARGET_DIR_MUSL="${OUTPUT_DIR}/linux_arm64_musl" ;;
+    esac
+
+    if [[ -f /etc/alpine-release ]] || command -v musl-gcc > /dev/null; then
+        if [[ ! -f /etc/alpine-release ]]; then
+            export "CC_${TARGET//-/_}=musl-gcc"
+        fi
+        echo "Building ${LIB_NAME} for ${TARGET}..."
+        rustup target add "${TARGET}"
+
+        (cd "${RUST_LIB_DIR}" && rustup run stable cargo build --release --target "${TARGET}")
+        mkdir -p "${TARGET_DIR_MUSL}"
 
-    cp "${RUST_LIB_DIR}/target/${TARGET}/release/${LIB_NAME}.a" "${TARGET_DIR_LINUX}/"
-    echo "Built for ${TARGET} and copied to ${TARGET_DIR_LINUX}/"
+        cp "${RUST_LIB_DIR}/target/${TARGET}/release/${LIB_NAME}.a" "${TARGET_DIR_MUSL}/"
+        echo "Built for ${TARGET} and copied to ${TARGET_DIR_MUSL}/"
+    else
+        echo "Skipping ${TARGET}: musl-gcc not found."
+    fi
 fi
 
 echo "All requested Rust libraries built successfully." 
\ No newline at end of file
@@ -3,6 +3,7 @@ module github.com/chirino/go-pgembed
 go 1.20 // Or your desired Go version
 
 require (
-	github.com/jmoiron/sqlx v1.4.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/lib/pq v1.10.9
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,299 @@
+package pgembed
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// serverConfigFile is the file, in the data directory, holding the settings
+// derived from Config. It is included from postgresql.conf and rewritten every
+// time the instance is started.
+const serverConfigFile = "pgembed.conf"
+
+// generatedPasswordFile is the file, in the data directory, holding the password
+// generated for the superuser when Config.Password is empty, so that it is used again
+// when the cluster is restarted.
+const generatedPasswordFile = "pgembed.password"
+
+// hbaRulesMarker starts the block of Config.HBARules appended to pg_hba.conf.
+const hbaRulesMarker = "# go-pgembed HBARules, rewritten on every start."
+
+// authMethods are the supported values of Config.AuthMethod.
+var authMethods = map[string]bool{
+	"trust":         true,
+	"password":      true,
+	"md5":           true,
+	"scram-sha-256": true,
+}
+
+// authMethod returns the authentication method to configure.
+func (c Config) authMethod() string {
+	if c.AuthMethod == "" {
+		return "password"
+	}
+	return c.AuthMethod
+}
+
+// serverSettings returns the postgresql.conf settings derived from the config.
+func (c Config) serverSettings() map[string]string {
+	settings := map[string]string{}
+	switch c.authMethod() {
+	case "md5", "scram-sha-256":
+		settings["password_encoding"] = c.authMethod()
+	}
+	if c.ListenAddresses != "" {
+		settings["listen_addresses"] = c.ListenAddresses
+	} else if c.Host != "" {
+		settings["listen_addresses"] = c.Host
+	}
+	if len(c.SharedPreloadLibraries) > 0 {
+		settings["shared_preload_libraries"] = strings.Join(c.SharedPreloadLibraries, ",")
+	}
+	if c.TimeZone != "" {
+		settings["timezone"] = c.TimeZone
+	}
+	if c.WALLevel != "" {
+		settings["wal_level"] = c.WALLevel
+	}
+	if c.MaxWALSenders > 0 {
+		settings["max_wal_senders"] = strconv.Itoa(c.MaxWALSenders)
+	}
+	if c.MaxConnections > 0 {
+		settings["max_connections"] = strconv.Itoa(c.MaxConnections)
+	}
+	if c.MaxReplicationSlots > 0 {
+		settings["max_replication_slots"] = strconv.Itoa(c.MaxReplicationSlots)
+	}
+	if c.TLS != nil {
+		for name, value := range c.TLS.serverSettings() {
+			settings[name] = value
+		}
+	}
+	return settings
+}
+
+// superuserPassword returns the password of the superuser of the cluster in dataDir:
+// Config.Password, the one generated when the cluster was created, or a new random
+// one. An existing cluster without a generated password predates them: its
+// password is unknown, so Config.Password must be set.
+func superuserPassword(dataDir string, config Config) (string, error) {
+	if config.Password != "" {
+		return config.Password, nil
+	}
+	content, err := os.ReadFile(filepath.Join(dataDir, generatedPasswordFile))
+	if err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", generatedPasswordFile, err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "PG_VERSION")); err == nil {
+		return "", fmt.Errorf("DataDir %s holds a cluster created without a generated password, set Config.Password to its superuser password", dataDir)
+	}
+	return randomPassword()
+}
+
+// randomPassword returns a random password for the superuser.
+func randomPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// initDataDir creates a new cluster in dataDir with initdb, unless it already
+// holds one.
+func initDataDir(binDir, dataDir, password string, config Config) error {
+	if _, err := os.Stat(filepath.Join(dataDir, "PG_VERSION")); err == nil {
+		if config.DataChecksums {
+			return checkDataChecksums(binDir, dataDir)
+		}
+		return nil
+	}
+
+	pwfile, err := os.CreateTemp("", "pgembed-pwfile-")
+	if err != nil {
+		return fmt.Errorf("failed to create password file: %w", err)
+	}
+	defer os.Remove(pwfile.Name())
+	_, err = pwfile.WriteString(password)
+	if closeErr := pwfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write password file: %w", err)
+	}
+
+	args := []string{
+		"--pgdata=" + dataDir,
+		"--username=" + config.superuser(),
+		"--pwfile=" + pwfile.Name(),
+		"--auth=" + config.authMethod(),
+		"--encoding=UTF8",
+	}
+	if config.DataChecksums {
+		args = append(args, "--data-checksums")
+	}
+	if config.LocaleProvider != "" {
+		args = append(args, "--locale-provider="+config.LocaleProvider)
+	}
+	if config.ICULocale != "" {
+		args = append(args, "--icu-locale="+config.ICULocale)
+	}
+	cmd := exec.Command(filepath.Join(binDir, executable("initdb")), args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("initdb failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if config.Password == "" {
+		if err := os.WriteFile(filepath.Join(dataDir, generatedPasswordFile), []byte(password), 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", generatedPasswordFile, err)
+		}
+	}
+	return nil
+}
+
+// checkDataChecksums checks with pg_controldata that the existing cluster in dataDir
+// has data checksums enabled.
+func checkDataChecksums(binDir, dataDir string) error {
+	cmd := exec.Command(filepath.Join(binDir, executable("pg_controldata")), "--pgdata="+dataDir)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("pg_controldata failed: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		if name == "Data page checksum version" {
+			if strings.TrimSpace(value) == "0" {
+				return fmt.Errorf("DataDir %s holds a cluster initialized without data checksums, they can only be enabled by initdb", dataDir)
+			}
+			return nil
+		}
+	}
+	return errors.New("pg_controldata did not report the data checksum version")
+}
+
+// baseBackup copies the cluster of the primary at primaryConnStr into dataDir with
+// pg_basebackup and configures it as its standby, unless dataDir already holds a
+// cluster. password is passed in PGPASSWORD rather than in primaryConnStr, so that it
+// doesn't show in the arguments of pg_basebackup.
+func baseBackup(binDir, dataDir, primaryConnStr, password string) error {
+	if _, err := os.Stat(filepath.Join(dataDir, "PG_VERSION")); err == nil {
+		return nil
+	}
+
+	args := []string{
+		"--pgdata=" + dataDir,
+		"--dbname=" + primaryConnStr,
+		"--write-recovery-conf", // standby.signal and primary_conninfo
+		"--wal-method=stream",
+		"--checkpoint=fast",
+	}
+	cmd := exec.Command(filepath.Join(binDir, executable("pg_basebackup")), args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_basebackup failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeServerConfig writes settings to the serverConfigFile in dataDir and
+// makes sure postgresql.conf includes it.
+func writeServerConfig(dataDir string, settings map[string]string) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# Generated by go-pgembed from its Config, do not edit.\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = %s\n", name, quoteConfigValue(settings[name]))
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, serverConfigFile), []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", serverConfigFile, err)
+	}
+
+	confPath := filepath.Join(dataDir, "postgresql.conf")
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		return fmt.Errorf("failed to read postgresql.conf: %w", err)
+	}
+	include := fmt.Sprintf("include_if_exists = '%s'", serverConfigFile)
+	if strings.Contains(string(conf), include) {
+		return nil
+	}
+	f, err := os.OpenFile(confPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open postgresql.conf: %w", err)
+	}
+	_, err = fmt.Fprintf(f, "\n%s\n", include)
+	return errors.Join(err, f.Close())
+}
+
+// quoteConfigValue quotes a postgresql.conf value as a string literal.
+func quoteConfigValue(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// writeHBARules replaces the block of rules previously appended to the
+// pg_hba.conf in dataDir with rules.
+func writeHBARules(dataDir string, rules []string) error {
+	for _, rule := range rules {
+		if strings.ContainsAny(rule, "\r\n") {
+			return fmt.Errorf("invalid HBARules entry %q: must be a single line", rule)
+		}
+	}
+
+	hbaPath := filepath.Join(dataDir, "pg_hba.conf")
+	hba, err := os.ReadFile(hbaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read pg_hba.conf: %w", err)
+	}
+	content, _, _ := strings.Cut(string(hba), hbaRulesMarker+"\n")
+	if len(rules) > 0 {
+		content += hbaRulesMarker + "\n" + strings.Join(rules, "\n") + "\n"
+	}
+	if err := os.WriteFile(hbaPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write pg_hba.conf: %w", err)
+	}
+	return nil
+}
+
+// checkPreloadLibraries verifies that the libraries exist in the package library
+// directory of the binaries in binDir; the server refuses to start otherwise,
+// without saying which library is missing.
+func checkPreloadLibraries(binDir string, libraries []string) error {
+	if len(libraries) == 0 {
+		return nil
+	}
+	output, err := exec.Command(filepath.Join(binDir, executable("pg_config")), "--pkglibdir").Output()
+	if err != nil {
+		return fmt.Errorf("failed to locate the library directory with pg_config: %w", err)
+	}
+	libDir := strings.TrimSpace(string(output))
+
+	for _, library := range libraries {
+		found := false
+		for _, ext := range []string{".so", ".dylib", ".dll"} {
+			if _, err := os.Stat(filepath.Join(libDir, library+ext)); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("shared preload library '%s' not found in %s", library, libDir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package pgembed
+
+import (
+	"database/sql"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteServerConfig(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+	confPath := filepath.Join(dataDir, "postgresql.conf")
+	if err := os.WriteFile(confPath, []byte("port = 5432\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := writeServerConfig(dataDir, map[string]string{"password_encoding": "md5", "timezone": "it's"})
+		if err != nil {
+			t.Fatalf("writeServerConfig() failed: %v", err)
+		}
+	}
+
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(conf), "include_if_exists = 'pgembed.conf'"); n != 1 {
+		t.Errorf("postgresql.conf includes pgembed.conf %d times, want 1:\n%s", n, conf)
+	}
+	settings, err := os.ReadFile(filepath.Join(dataDir, serverConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(settings), "password_encoding = 'md5'\ntimezone = 'it''s'\n") {
+		t.Errorf("unexpected %s:\n%s", serverConfigFile, settings)
+	}
+}
+
+func TestNewWithInvalidAuthMethod(t *testing.T) {
+	_, err := New(Config{Version: DefaultVersion, AuthMethod: "ident"})
+	if err == nil || !strings.Contains(err.Error(), "AuthMethod") {
+		t.Fatalf("New() error = %v, want an unsupported AuthMethod error", err)
+	}
+}
+
+func TestAuthMethodScram(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:    DefaultVersion,
+		DataDir:    dataDir,
+		RuntimeDir: dataDir,
+		Password:   "secret",
+		AuthMethod: "scram-sha-256",
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	db, err := pg.openDB("")
+	if err != nil {
+		t.Fatalf("openDB() failed: %v", err)
+	}
+	defer db.Close()
+
+	var hash string
+	if err := db.QueryRow("SELECT rolpassword FROM pg_authid WHERE rolname = current_user").Scan(&hash); err != nil {
+		t.Fatalf("failed to query password hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "SCRAM-SHA-256$") {
+		t.Errorf("password hash = %q, want a SCRAM-SHA-256 hash", hash)
+	}
+}
+
+func TestWriteHBARules(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+	hbaPath := filepath.Join(dataDir, "pg_hba.conf")
+	initial := "local all all password\n"
+	if err := os.WriteFile(hbaPath, []byte(initial), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeHBARules(dataDir, []string{"host all all 10.0.0.0/8 md5"}); err != nil {
+		t.Fatalf("writeHBARules() failed: %v", err)
+	}
+	if err := writeHBARules(dataDir, []string{"host all all 172.17.0.0/16 md5"}); err != nil {
+		t.Fatalf("writeHBARules() failed: %v", err)
+	}
+	hba, err := os.ReadFile(hbaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := initial + hbaRulesMarker + "\nhost all all 172.17.0.0/16 md5\n"
+	if string(hba) != want {
+		t.Errorf("pg_hba.conf = %q, want %q", hba, want)
+	}
+
+	if err := writeHBARules(dataDir, []string{"host all all all trust\nlocal all all trust"}); err == nil {
+		t.Error("writeHBARules() accepted a multi-line rule")
+	}
+}
+
+func TestRemoteConnections(t *testing.T) {
+	var remoteIP net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			remoteIP = ipNet.IP
+			break
+		}
+	}
+	if remoteIP == nil {
+		t.Skip("no non-loopback IPv4 address available")
+	}
+
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:         DefaultVersion,
+		DataDir:         dataDir,
+		RuntimeDir:      dataDir,
+		ListenAddresses: "*",
+		HBARules:        []string{"host all all " + remoteIP.String() + "/32 password"},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	connStr, err := pg.ConnectionString("")
+	if err != nil {
+		t.Fatalf("ConnectionString() failed: %v", err)
+	}
+	remoteConnStr := strings.Replace(connStr, "@localhost:", "@"+remoteIP.String()+":", 1)
+	db, err := sql.Open("postgres", remoteConnStr)
+	if err != nil {
+		t.Fatalf("sql.Open(%s) failed: %v", remoteConnStr, err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Errorf("connecting over %s failed: %v", remoteIP, err)
+	}
+}
+
+func TestSharedPreloadLibraries(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:                DefaultVersion,
+		DataDir:                dataDir,
+		RuntimeDir:             dataDir,
+		SharedPreloadLibraries: []string{"pg_stat_statements", "auto_explain"},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	db, err := pg.openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var libraries string
+	if err := db.QueryRow("SHOW shared_preload_libraries").Scan(&libraries); err != nil {
+		t.Fatalf("failed to query shared_preload_libraries: %v", err)
+	}
+	if libraries != "pg_stat_statements,auto_explain" {
+		t.Errorf("shared_preload_libraries = %q, want pg_stat_statements,auto_explain", libraries)
+	}
+}
+
+func TestDataChecksums(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, DataChecksums: true})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if got, err := pg.ShowSetting("data_checksums"); err != nil || got != "on" {
+		t.Errorf("ShowSetting(\"data_checksums\") = %q, %v, want on", got, err)
+	}
+}
+
+func TestCheckDataChecksums(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pg_controldata is a shell script")
+	}
+	binDir := tempDir(t)
+	defer os.RemoveAll(binDir)
+
+	for version, wantErr := range map[string]bool{"0": true, "1": false} {
+		script := "#!/bin/sh\necho 'Data page checksum version:           " + version + "'\n"
+		if err := os.WriteFile(filepath.Join(binDir, "pg_controldata"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkDataChecksums(binDir, "/data"); (err != nil) != wantErr {
+			t.Errorf("checkDataChecksums() with checksum version %s error = %v, want error %v", version, err, wantErr)
+		}
+	}
+}
+
+func TestPersistedSuperuserPassword(t *testing.T) {
+	dataDir := t.TempDir()
+
+	if got, err := superuserPassword(dataDir, Config{Password: "secret"}); err != nil || got != "secret" {
+		t.Errorf("superuserPassword() with Config.Password = %q, %v, want secret", got, err)
+	}
+	first, err := superuserPassword(dataDir, Config{})
+	if err != nil {
+		t.Fatalf("superuserPassword() failed: %v", err)
+	}
+	second, err := superuserPassword(dataDir, Config{})
+	if err != nil {
+		t.Fatalf("superuserPassword() failed: %v", err)
+	}
+	if len(first) != 32 || first == second {
+		t.Errorf("superuserPassword() = %q then %q, want different random passwords", first, second)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, generatedPasswordFile), []byte(first), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := superuserPassword(dataDir, Config{}); err != nil || got != first {
+		t.Errorf("superuserPassword() = %q, %v, want the generated password %q", got, err, first)
+	}
+
+	oldDataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(oldDataDir, "PG_VERSION"), []byte("16\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := superuserPassword(oldDataDir, Config{}); err == nil {
+		t.Errorf("superuserPassword() of a cluster without a generated password = %q, want an error", got)
+	}
+	if got, err := superuserPassword(oldDataDir, Config{Password: "secret"}); err != nil || got != "secret" {
+		t.Errorf("superuserPassword() of a cluster without a generated password with Config.Password = %q, %v, want secret", got, err)
+	}
+}
+
+func TestGeneratedPasswordOnRestart(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	config := Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir}
+	pg, err := New(config)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	password, err := pg.SuperuserPassword()
+	if err != nil {
+		t.Fatalf("SuperuserPassword() failed: %v", err)
+	}
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+
+	pg, err = New(config)
+	if err != nil {
+		t.Fatalf("New() of an existing cluster failed: %v", err)
+	}
+	defer pg.Stop()
+	if got, _ := pg.SuperuserPassword(); got != password {
+		t.Errorf("SuperuserPassword() = %q after a restart, want %q", got, password)
+	}
+	if err := pg.Exec("", "SELECT 1"); err != nil {
+		t.Errorf("failed to connect with the generated password after a restart: %v", err)
+	}
+}
+
+func TestICULocale(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, LocaleProvider: "icu", ICULocale: "en-US"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	var provider, locale string
+	err = pg.QueryRow("", "SELECT datlocprovider, daticulocale FROM pg_database WHERE datname = current_database()").Scan(&provider, &locale)
+	if err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if provider != "i" || locale != "en-US" {
+		t.Errorf("database has locale provider %q and ICU locale %q, want i and en-US", provider, locale)
+	}
+
+	if _, err := New(Config{Version: DefaultVersion, ICULocale: "en-US"}); err == nil || !strings.Contains(err.Error(), "LocaleProvider") {
+		t.Errorf("New() with ICULocale but no LocaleProvider error = %v, want a LocaleProvider error", err)
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, TimeZone: "Asia/Tokyo"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if got, err := pg.ShowSetting("timezone"); err != nil || got != "Asia/Tokyo" {
+		t.Errorf("ShowSetting(\"timezone\") = %q, %v, want Asia/Tokyo", got, err)
+	}
+}
+
+func TestWALLevel(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:             DefaultVersion,
+		DataDir:             dataDir,
+		RuntimeDir:          dataDir,
+		WALLevel:            "logical",
+		MaxWALSenders:       4,
+		MaxReplicationSlots: 6,
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	for name, want := range map[string]string{"wal_level": "logical", "max_wal_senders": "4", "max_replication_slots": "6"} {
+		if got, err := pg.ShowSetting(name); err != nil || got != want {
+			t.Errorf("ShowSetting(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if _, err := New(Config{Version: DefaultVersion, WALLevel: "minimal"}); err == nil {
+		t.Error("New() accepted WALLevel minimal")
+	}
+}
+
+func TestMissingSharedPreloadLibrary(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	_, err := New(Config{
+		Version:                DefaultVersion,
+		DataDir:                dataDir,
+		RuntimeDir:             dataDir,
+		SharedPreloadLibraries: []string{"no_such_library"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "no_such_library") {
+		t.Fatalf("New() error = %v, want an error naming no_such_library", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	if err := (Config{Version: DefaultVersion, Host: "not an address"}).Validate(); err == nil {
+		t.Error("Validate() with an invalid Host succeeded")
+	}
+
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, Host: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	connStr, err := pg.ConnectionString("")
+	if err != nil {
+		t.Fatalf("ConnectionString() failed: %v", err)
+	}
+	u, err := url.Parse(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Hostname() != "127.0.0.1" {
+		t.Errorf("ConnectionString() host = %q, want 127.0.0.1", u.Hostname())
+	}
+	listen, err := pg.ShowSetting("listen_addresses")
+	if err != nil {
+		t.Fatalf("ShowSetting() failed: %v", err)
+	}
+	if listen != "127.0.0.1" {
+		t.Errorf("listen_addresses = %q, want 127.0.0.1", listen)
+	}
+	if err := pg.Exec("", "SELECT 1"); err != nil {
+		t.Errorf("failed to connect through 127.0.0.1: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// largeObjectChunkSize is how many bytes of a large object are transferred per query.
+const largeObjectChunkSize = 1 << 20
+
+// ImportLargeObject stores the data read from data as a new large object in dbName
+// and returns its OID. The large object is created in a transaction, so nothing is
+// left behind if reading data fails.
+func (pg *EmbeddedPostgres) ImportLargeObject(dbName string, data io.Reader) (uint32, error) {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction in database '%s': %w", dbName, err)
+	}
+	defer tx.Rollback()
+
+	var oid uint32
+	if err := tx.QueryRow("SELECT lo_create(0)").Scan(&oid); err != nil {
+		return 0, fmt.Errorf("failed to create large object: %w", err)
+	}
+	buf := make([]byte, largeObjectChunkSize)
+	for offset := int64(0); ; {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			if _, err := tx.Exec("SELECT lo_put($1, $2, $3)", oid, offset, buf[:n]); err != nil {
+				return 0, fmt.Errorf("failed to write large object %d: %w", oid, err)
+			}
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read large object data: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit large object %d: %w", oid, err)
+	}
+	return oid, nil
+}
+
+// ExportLargeObject writes the contents of the large object oid in dbName to w. It is
+// read in a transaction, so it is consistent even if the large object is being changed.
+func (pg *EmbeddedPostgres) ExportLargeObject(dbName string, oid uint32, w io.Writer) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction in database '%s': %w", dbName, err)
+	}
+	defer tx.Rollback()
+
+	for offset := int64(0); ; {
+		var chunk []byte
+		if err := tx.QueryRow("SELECT lo_get($1, $2, $3)", oid, offset, largeObjectChunkSize).Scan(&chunk); err != nil {
+			return fmt.Errorf("failed to read large object %d: %w", oid, err)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("failed to write large object %d: %w", oid, err)
+		}
+		if len(chunk) < largeObjectChunkSize {
+			return nil
+		}
+		offset += int64(len(chunk))
+	}
+}
@@ -0,0 +1,48 @@
+package pgembed
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestLargeObjects(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	// More than one chunk, and not a multiple of the chunk size.
+	data := make([]byte, 2*largeObjectChunkSize+123)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	oid, err := pg.ImportLargeObject("", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ImportLargeObject() failed: %v", err)
+	}
+	var got bytes.Buffer
+	if err := pg.ExportLargeObject("", oid, &got); err != nil {
+		t.Fatalf("ExportLargeObject() failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("ExportLargeObject() returned %d bytes, want the %d imported ones", got.Len(), len(data))
+	}
+
+	empty, err := pg.ImportLargeObject("", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("ImportLargeObject() of no data failed: %v", err)
+	}
+	got.Reset()
+	if err := pg.ExportLargeObject("", empty, &got); err != nil || got.Len() != 0 {
+		t.Errorf("ExportLargeObject() of an empty large object = %d bytes, %v", got.Len(), err)
+	}
+
+	if err := pg.ExportLargeObject("", 4242424, &got); err == nil {
+		t.Error("ExportLargeObject() of a missing large object succeeded")
+	}
+}
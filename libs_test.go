@@ -0,0 +1,40 @@
+package pgembed
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rustLibraryMarkers are strings of rust/src/lib.rs that a library built from it
+// contains. A prebuilt library without them predates the Go code using it, and must be
+// rebuilt with go generate.
+var rustLibraryMarkers = []string{
+	"trust_installation_dir", // apply_options, the options of pg_embedded_create_and_start
+	"unknown option",
+	"panic in the Rust layer", // catch_panic
+	"pg_embedded_test_panic",
+	"pg_embedded_test_string_counts",
+}
+
+func TestPrebuiltLibrariesUpToDate(t *testing.T) {
+	libraries, err := filepath.Glob(filepath.Join("libs", "*", "libgo_pgembed_lib.a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libraries) == 0 {
+		t.Fatal("no prebuilt library in libs")
+	}
+	for _, library := range libraries {
+		content, err := os.ReadFile(library)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, marker := range rustLibraryMarkers {
+			if !bytes.Contains(content, []byte(marker)) {
+				t.Errorf("%s does not contain %q: it is older than rust/src/lib.rs, rebuild it with go generate", library, marker)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package pgembed
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// VacuumOptions controls Vacuum.
+type VacuumOptions struct {
+	// Full rewrites the tables to reclaim all free space, locking them exclusively.
+	Full bool
+	// Analyze also updates the planner statistics, like Analyze.
+	Analyze bool
+}
+
+// sql returns the VACUUM command for the options.
+func (o VacuumOptions) sql() string {
+	var options []string
+	if o.Full {
+		options = append(options, "FULL")
+	}
+	if o.Analyze {
+		options = append(options, "ANALYZE")
+	}
+	if len(options) == 0 {
+		return "VACUUM"
+	}
+	return "VACUUM (" + strings.Join(options, ", ") + ")"
+}
+
+// Vacuum runs VACUUM on all tables of dbName, e.g. so that EXPLAIN output is stable.
+func (pg *EmbeddedPostgres) Vacuum(dbName string, opts VacuumOptions) error {
+	return pg.maintain(dbName, opts.sql())
+}
+
+// Analyze runs ANALYZE on all tables of dbName, updating the planner statistics.
+func (pg *EmbeddedPostgres) Analyze(dbName string) error {
+	return pg.maintain(dbName, "ANALYZE")
+}
+
+// Reindex rebuilds all indexes of dbName with REINDEX DATABASE.
+func (pg *EmbeddedPostgres) Reindex(dbName string) error {
+	name := dbName
+	if name == "" {
+		name = "postgres"
+	}
+	if err := validateIdentifier("database name", name); err != nil {
+		return err
+	}
+	return pg.maintain(dbName, "REINDEX DATABASE "+pq.QuoteIdentifier(name))
+}
+
+// maintain runs the maintenance command in dbName, including it in the error.
+func (pg *EmbeddedPostgres) maintain(dbName, command string) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(command); err != nil {
+		return fmt.Errorf("%s failed in database '%s': %w", command, dbName, err)
+	}
+	return nil
+}
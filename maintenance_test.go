@@ -0,0 +1,61 @@
+package pgembed
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVacuumOptions(t *testing.T) {
+	tests := []struct {
+		opts VacuumOptions
+		want string
+	}{
+		{VacuumOptions{}, "VACUUM"},
+		{VacuumOptions{Full: true}, "VACUUM (FULL)"},
+		{VacuumOptions{Full: true, Analyze: true}, "VACUUM (FULL, ANALYZE)"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.sql(); got != tt.want {
+			t.Errorf("%+v.sql() = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("maintained", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	if err := pg.Exec("maintained", "CREATE TABLE items AS SELECT generate_series(1, 1000) AS id"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.Exec("maintained", "CREATE INDEX ON items (id)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	if err := pg.Analyze("maintained"); err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	var tuples float64
+	if err := pg.QueryRow("maintained", "SELECT reltuples FROM pg_class WHERE relname = 'items'").Scan(&tuples); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if tuples != 1000 {
+		t.Errorf("reltuples = %v after Analyze(), want 1000", tuples)
+	}
+
+	if err := pg.Vacuum("maintained", VacuumOptions{Full: true, Analyze: true}); err != nil {
+		t.Errorf("Vacuum() failed: %v", err)
+	}
+	if err := pg.Reindex("maintained"); err != nil {
+		t.Errorf("Reindex() failed: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package pgembed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Listen listens on channel in dbName, or the "postgres" database if dbName is empty,
+// using a dedicated connection. The payloads of the notifications are sent to the
+// returned channel, which is closed when ctx is done or the connection is lost.
+// Notifications are delivered in order; read them promptly, the connection stalls while
+// a payload waits to be received.
+func (pg *EmbeddedPostgres) Listen(ctx context.Context, dbName, channel string) (<-chan string, error) {
+	dsn, err := pg.ConnectionString(dbName)
+	if err != nil {
+		return nil, err
+	}
+	notifications := make(chan *pq.Notification, 32)
+	cn, err := pq.NewListenerConn(dsn, notifications)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database '%s': %w", dbName, err)
+	}
+	if _, err := cn.Listen(channel); err != nil {
+		cn.Close()
+		return nil, fmt.Errorf("failed to listen on channel '%s': %w", channel, err)
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		defer func() {
+			cn.Close()
+			// Unblocks the connection if it is delivering a notification; it closes
+			// notifications when it's done.
+			for range notifications {
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-notifications:
+				if !ok {
+					return
+				}
+				select {
+				case payloads <- n.Extra:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return payloads, nil
+}
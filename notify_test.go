@@ -0,0 +1,50 @@
+package pgembed
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	payloads, err := pg.Listen(ctx, "", "events")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	for _, payload := range []string{"first", "second"} {
+		if err := pg.Exec("", "SELECT pg_notify('events', $1)", payload); err != nil {
+			t.Fatalf("Exec() failed: %v", err)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-payloads:
+			if got != want {
+				t.Errorf("received %q, want %q", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no notification received, want %q", want)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-payloads:
+		if ok {
+			t.Error("received a notification after cancelling, want the channel closed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("the channel was not closed after cancelling")
+	}
+}
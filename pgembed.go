@@ -1,158 +1,690 @@
 package pgembed
 
-/*
-// These CGO flags assume the Rust library has been compiled and is available.
-// You need to compile the Rust library first:
-//
-//     go generate
-//
-
-// Common linker flags needed by Rust standard library and dependencies.
-// Adjust if your Rust code has other specific system dependencies.
-#cgo darwin LDFLAGS: -L./libs/darwin_arm64 -lgo_pgembed_lib -ldl -lm -framework Security -framework CoreFoundation -framework SystemConfiguration -llzma -mmacosx-version-min=15.1
-#cgo linux LDFLAGS: -L./libs/linux_amd64 -lgo_pgembed_lib -ldl -lm -lrt -lpthread -llzma
-#cgo windows LDFLAGS: -L./libs/windows_amd64 -lgo_pgembed_lib -lws2_32 -luserenv -ladvapi32 -lbcrypt -lntdll -llzma
-
-// C function declarations matching the Rust FFI.
-// Using `typedef struct RustEmbeddedPg RustEmbeddedPg;` for the opaque pointer.
-#include <stdlib.h> // For C.free
-#include <stdbool.h> // For C._Bool (Go bool)
-
-typedef struct RustEmbeddedPg RustEmbeddedPg; // Opaque struct
-
-// Define the result struct to match Rust's PgStartResult
-typedef struct {
-    RustEmbeddedPg* pg_ptr;
-    char* error_msg;
-} PgStartResult;
-
-PgStartResult pg_embedded_create_and_start(
-    const char* data_dir_str,
-    const char* runtime_dir_str,
-    unsigned short port,
-    const char* password_str
-);
-
-bool pg_embedded_stop(RustEmbeddedPg* pg_ptr);
-
-char* pg_embedded_get_connection_string(const RustEmbeddedPg* pg_ptr, const char* db_name_str);
-
-bool pg_embedded_create_database(RustEmbeddedPg* pg_ptr, const char* db_name_str);
-
-bool pg_embedded_drop_database(RustEmbeddedPg* pg_ptr, const char* db_name_str);
-
-bool pg_embedded_database_exists(const RustEmbeddedPg* pg_ptr, const char* db_name_str);
-
-void pg_embedded_free_string(char* s);
-*/
-import "C"
 import (
+	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
-	"unsafe"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 // EmbeddedPostgres represents an embedded PostgreSQL instance.
 type EmbeddedPostgres struct {
-	instance *C.RustEmbeddedPg
-	config   Config // Store config for reference
+	instance        serverInstance
+	config          Config   // Store config for reference
+	version         string   // Config.Version resolved to an exact version, or the one of BinariesPath
+	binDir          string   // bin directory of the PostgreSQL binaries
+	dataDir         string   // absolute path of the data directory
+	runtimeDir      string   // absolute path of the socket directory, empty on Windows
+	tempDirs        []string // temporary directories created by New, removed by Stop
+	started         time.Time
+	metrics         StartupMetrics
+	password        string     // of the superuser
+	logTailer       *logTailer // copies the server log to Config.LogWriter, if set
+	releaseBinaries func()     // marks the binaries as no longer used, see PurgeCache
+
+	poolsMu sync.Mutex
+	pools   map[string]*sql.DB // connection pools by database name, see db
 }
 
 // Config holds configuration for the embedded PostgreSQL.
 type Config struct {
-	// Version of PostgreSQL to use (e.g., "16.2.0", "15.6.0", "14.11.0", etc.). Mandatory.
-	// Check postgresql-embedded crate for supported versions.
+	// Version of PostgreSQL to use (e.g., "16.2.0", "15.6.0", "14.11.0", etc.). Mandatory,
+	// unless UseDefaultVersion or BinariesPath is set.
+	// A major version such as "16" selects the newest release of that major version, and
+	// "latest" (LatestVersion) the newest release overall. See AvailableVersions.
 	Version string
+	// UseDefaultVersion selects DefaultVersion when Version is empty.
+	UseDefaultVersion bool
 	// DataDir is the path to the PostgreSQL data directory.
 	// If empty, a temporary directory managed by the Rust library will be used.
 	DataDir string
+	// RemoveStaleLock removes the postmaster.pid file left in DataDir by a server that
+	// was killed, when its process is no longer a live postgres process. The server may
+	// otherwise refuse to start if the process ID has been reused.
+	RemoveStaleLock bool
 	// RuntimeDir is the path for runtime files (e.g., sockets).
-	// If empty, a temporary directory managed by the Rust library will be used.
+	// If empty, a temporary directory is used, removed by Stop.
 	RuntimeDir string
 	// Port for PostgreSQL to listen on. If 0, a random available port will be chosen.
 	Port uint16
-	// Password for the default 'postgres' user. If empty, password may not be set or a default used.
+	// SuperuserName is the name of the superuser created by initdb. Defaults to "postgres".
+	SuperuserName string
+	// Password for the superuser. If empty, a random password is generated when the
+	// cluster is created, and kept in DataDir to be used again when it is restarted, see
+	// EmbeddedPostgres.SuperuserPassword. It is required to start a cluster created
+	// before passwords were generated.
 	Password string
+	// AuthMethod is the authentication method initdb configures in pg_hba.conf for local
+	// and host connections: "trust", "password", "md5" or "scram-sha-256". Defaults to
+	// "password". With "md5" or "scram-sha-256", passwords are also stored using that
+	// encoding. With "trust", connections don't need a password.
+	AuthMethod string
+	// Host is the address the server listens on, e.g. "127.0.0.1" or the address of a
+	// specific interface, and the host of connection strings. Defaults to "localhost".
+	// ListenAddresses, if set, takes precedence for listen_addresses.
+	Host string
+	// ListenAddresses sets listen_addresses, the interfaces the server accepts TCP
+	// connections on, e.g. "*" for all of them. Defaults to "localhost".
+	//
+	// Accepting connections from other hosts also requires HBARules that allow them.
+	// Anyone who can reach the port can then attempt to log in, so keep the rules as
+	// narrow as possible and never combine them with the "trust" method outside of an
+	// isolated network.
+	ListenAddresses string
+	// HBARules are appended to pg_hba.conf, e.g. "host all all 172.17.0.0/16 scram-sha-256".
+	HBARules []string
+	// TLS enables TLS connections using the given certificates. Connection strings then
+	// require encryption rather than disabling it.
+	TLS *TLSConfig
+	// SharedPreloadLibraries are loaded when the server starts (shared_preload_libraries),
+	// e.g. "pg_stat_statements". New fails, naming the library, if one doesn't exist.
+	SharedPreloadLibraries []string
+	// DataChecksums initializes the cluster with data page checksums (initdb
+	// --data-checksums), so that corrupted pages are detected. It only applies when the
+	// cluster is created: New fails if DataDir holds one initialized without them.
+	DataChecksums bool
+	// LocaleProvider is the default locale provider of the cluster, "libc", the default,
+	// or "icu" (PostgreSQL 15 or later), which requires ICULocale. Like DataChecksums, it
+	// only applies when the cluster is created.
+	LocaleProvider string
+	// ICULocale is the ICU locale used with LocaleProvider "icu", e.g. "en-US".
+	ICULocale string
+	// TimeZone is the timezone setting of the server, e.g. "UTC", used to display and
+	// interpret timestamps. The time zone of the host, as detected by initdb, is used if
+	// empty.
+	TimeZone string
+	// WALLevel is the wal_level of the server: "replica", the default, or "logical", which
+	// logical decoding and replication require, see CreateLogicalSlot. Like the other
+	// replication settings, it only takes effect when the server starts.
+	WALLevel string
+	// MaxWALSenders is max_wal_senders, the maximum number of replication connections.
+	// The server default of 10 is used if 0.
+	MaxWALSenders int
+	// MaxReplicationSlots is max_replication_slots, the maximum number of replication
+	// slots. The server default of 10 is used if 0.
+	MaxReplicationSlots int
+	// MaxConnections is max_connections, the maximum number of concurrent connections to
+	// the server, see EmbeddedPostgres.OpenDB. The server default of 100 is used if 0.
+	MaxConnections int
+	// Databases are created when the instance is started, if they don't exist yet.
+	Databases []DatabaseSpec
+	// SSLMode is the sslmode of connection strings: "disable", "allow", "prefer",
+	// "require", "verify-ca" or "verify-full". Defaults to "disable", or when TLS is
+	// set, to "require" or "verify-full" depending on whether TLS.CAFile is set.
+	SSLMode string
+	// ApplicationName is the application_name of connection strings, which identifies
+	// their connections in pg_stat_activity and the server log.
+	ApplicationName string
+	// ConnectTimeout is the connect_timeout of connection strings, how long connecting
+	// may take, rounded up to seconds. Connections wait indefinitely if 0.
+	ConnectTimeout time.Duration
+	// StatementTimeout is the statement_timeout of the connections of connection strings,
+	// including the ones of the helper methods, so that a runaway query fails rather than
+	// hanging. It is set with the options parameter, rounded up to milliseconds.
+	// Statements aren't interrupted if 0.
+	StatementTimeout time.Duration
+	// SearchPath is the search_path of the connections of connection strings, the
+	// schemas in which unqualified names are looked up and created, e.g. "tenant1,
+	// public". It is set with the options parameter, see ConnectionStringWithSearchPath.
+	SearchPath string
+	// BinariesPath is the path to a directory of already-extracted PostgreSQL binaries.
+	// When set, nothing is downloaded and the version is the one of the binaries, so
+	// Version, UseDefaultVersion, Offline and DownloadBaseURL must not be set. The directory must have the layout
+	// of an extracted postgresql-binaries release archive:
+	//
+	//	<BinariesPath>/bin/   initdb, pg_ctl, postgres, psql, ...
+	//	<BinariesPath>/lib/   shared libraries and extension modules
+	//	<BinariesPath>/share/ extension control files, timezone data, ...
+	BinariesPath string
+	// Offline prevents binaries from being downloaded. The binaries for Version must
+	// already be present in CacheDir, otherwise New fails with ErrBinariesNotFound.
+	Offline bool
+	// DownloadBaseURL overrides where the PostgreSQL binaries are downloaded from, e.g. an
+	// internal mirror of https://github.com/theseus-rs/postgresql-binaries/releases/download.
+	// The placeholders {version} and {target} (e.g. "x86_64-unknown-linux-gnu") are
+	// replaced to build the archive URL:
+	//
+	//	https://mirror.example.com/postgresql/{version}/postgresql-{version}-{target}.tar.gz
+	//
+	// Without placeholders, "/{version}/postgresql-{version}-{target}.tar.gz" is appended.
+	// The releases of a mirror can't be listed, so Version must be an exact version.
+	DownloadBaseURL string
+	// CacheDir is where downloaded binaries are cached, one sub-directory per version.
+	// If empty, ~/.theseus/postgresql is used. Instances may share a cache directory;
+	// concurrent downloads of the same version are serialized.
+	CacheDir string
+	// DownloadProgress, if set, is called periodically while the binaries are being
+	// downloaded, with the number of bytes downloaded so far and the total size of the
+	// archive, or -1 when the size is unknown. It is not called when the binaries are
+	// already cached.
+	DownloadProgress func(downloaded, total int64)
+	// DownloadTimeout bounds how long downloading the binaries may take. New fails with
+	// ErrDownloadTimeout when it is exceeded. Defaults to 10 minutes.
+	DownloadTimeout time.Duration
+	// StartupTimeout bounds how long starting the server may take, once the binaries
+	// are available. New fails with ErrStartupTimeout when it is exceeded. Defaults to
+	// 30 seconds.
+	StartupTimeout time.Duration
+	// StartRetries is how many more times New tries to start the server when Port is 0
+	// and the random port it picked was taken by another process in the meantime
+	// (ErrPortInUse). Defaults to 0, no retries.
+	StartRetries int
+	// DisableFinalizer prevents an instance that is garbage collected without having been
+	// stopped from being stopped, e.g. for an instance held by a long-lived singleton.
+	// Stop must then be called, or the server keeps running, possibly after the process
+	// exits, and the temporary directories are never removed.
+	DisableFinalizer bool
+	// DriverName is the database/sql driver the helper methods, such as Exec or OpenDB,
+	// connect with: "postgres", lib/pq, the default, or another driver accepting the same
+	// connection strings, such as "pgx", which the caller registers by importing
+	// github.com/jackc/pgx/v5/stdlib. Listen always uses lib/pq.
+	DriverName string
+	// LogWriter, if set, receives the lines of the server log, one per Write call, as
+	// they are written, e.g. to show them in the output of tests. The server then logs to
+	// log/pgembed.log in DataDir with the logging collector.
+	LogWriter io.Writer
+}
+
+// DatabaseSpec describes a database created by New, see Config.Databases.
+type DatabaseSpec struct {
+	// Name of the database. Mandatory.
+	Name string
+	// Owner is the role owning the database. Defaults to the superuser.
+	Owner string
+	// Encoding is the character set encoding, e.g. "UTF8". Defaults to the encoding of
+	// the template. An encoding different from template1's requires Template "template0".
+	Encoding string
+	// Template is the database the new one is copied from. Defaults to "template1".
+	Template string
+}
+
+// Validate checks the config for mistakes without starting anything, returning an
+// error listing all the problems found. New validates the config first.
+func (c Config) Validate() error {
+	var errs []error
+	switch {
+	case c.BinariesPath != "" && (c.Version != "" || c.UseDefaultVersion):
+		errs = append(errs, errors.New("Version and UseDefaultVersion cannot be combined with BinariesPath: the version is the one of the binaries"))
+	case c.Version == "" && (c.UseDefaultVersion || c.BinariesPath != ""):
+	case c.Version == "":
+		errs = append(errs, errors.New("PostgreSQL version must be specified in Config"))
+	case c.Version != LatestVersion && !versionSelector.MatchString(c.Version):
+		errs = append(errs, fmt.Errorf("invalid Version %q: expected a version such as \"16.2.0\", \"16\" or %q", c.Version, LatestVersion))
+	}
+	if c.BinariesPath != "" && c.Offline {
+		errs = append(errs, errors.New("Offline cannot be combined with BinariesPath, which never downloads"))
+	}
+	if c.BinariesPath != "" && c.DownloadBaseURL != "" {
+		errs = append(errs, errors.New("DownloadBaseURL cannot be combined with BinariesPath, which never downloads"))
+	}
+	if c.Host != "" && c.Host != "localhost" && net.ParseIP(c.Host) == nil {
+		errs = append(errs, fmt.Errorf("invalid Host %q: expected an IP address or localhost", c.Host))
+	}
+	if c.AuthMethod != "" && !authMethods[c.AuthMethod] {
+		errs = append(errs, fmt.Errorf("unsupported AuthMethod %q: must be one of trust, password, md5 or scram-sha-256", c.AuthMethod))
+	}
+	switch {
+	case c.LocaleProvider != "" && c.LocaleProvider != "libc" && c.LocaleProvider != "icu":
+		errs = append(errs, fmt.Errorf("unsupported LocaleProvider %q: must be libc or icu", c.LocaleProvider))
+	case c.LocaleProvider == "icu" && c.ICULocale == "":
+		errs = append(errs, errors.New("LocaleProvider icu requires ICULocale"))
+	case c.LocaleProvider != "icu" && c.ICULocale != "":
+		errs = append(errs, errors.New("ICULocale requires LocaleProvider icu"))
+	}
+	if c.WALLevel != "" && c.WALLevel != "replica" && c.WALLevel != "logical" {
+		errs = append(errs, fmt.Errorf("unsupported WALLevel %q: must be replica or logical", c.WALLevel))
+	}
+	if c.MaxWALSenders < 0 {
+		errs = append(errs, fmt.Errorf("invalid MaxWALSenders %d: must not be negative", c.MaxWALSenders))
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("invalid MaxConnections %d: must not be negative", c.MaxConnections))
+	}
+	if c.MaxReplicationSlots < 0 {
+		errs = append(errs, fmt.Errorf("invalid MaxReplicationSlots %d: must not be negative", c.MaxReplicationSlots))
+	}
+	if c.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid ConnectTimeout %v: must not be negative", c.ConnectTimeout))
+	}
+	if c.StatementTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid StatementTimeout %v: must not be negative", c.StatementTimeout))
+	}
+	if c.SSLMode != "" && !sslModes[c.SSLMode] {
+		errs = append(errs, fmt.Errorf("unsupported SSLMode %q", c.SSLMode))
+	}
+	if !driverRegistered(c.driverName()) {
+		errs = append(errs, fmt.Errorf("database/sql driver %q is not registered: import its package, e.g. github.com/jackc/pgx/v5/stdlib for pgx", c.DriverName))
+	} else if c.driverName() == "postgres" && (c.SSLMode == "allow" || c.SSLMode == "prefer") {
+		errs = append(errs, fmt.Errorf("SSLMode %q is not supported by lib/pq, the \"postgres\" driver: use another SSLMode or DriverName \"pgx\"", c.SSLMode))
+	}
+	if c.TLS == nil {
+		switch c.SSLMode {
+		case "require", "verify-ca", "verify-full":
+			errs = append(errs, fmt.Errorf("SSLMode %q requires TLS", c.SSLMode))
+		}
+	} else if c.TLS.SelfSigned {
+		if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+			errs = append(errs, errors.New("TLS.SelfSigned cannot be combined with CertFile or KeyFile"))
+		}
+	} else if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+		errs = append(errs, errors.New("TLS requires both CertFile and KeyFile, or SelfSigned"))
+	}
+	if c.DataDir != "" {
+		if err := checkWritable(c.DataDir); err != nil {
+			errs = append(errs, fmt.Errorf("DataDir: %w", err))
+		}
+	}
+	if c.RuntimeDir != "" {
+		if err := checkWritable(c.RuntimeDir); err != nil {
+			errs = append(errs, fmt.Errorf("RuntimeDir: %w", err))
+		}
+	}
+	for _, spec := range c.Databases {
+		if err := validateIdentifier("database name", spec.Name); err != nil {
+			errs = append(errs, fmt.Errorf("Databases: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// driverName returns the database/sql driver to connect with.
+func (c Config) driverName() string {
+	if c.DriverName == "" {
+		return "postgres"
+	}
+	return c.DriverName
+}
+
+// driverRegistered reports whether the database/sql driver name is registered.
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlState returns the SQLSTATE error code of err, e.g. "42P01" for undefined_table,
+// or "" if it isn't a server error. The errors of lib/pq and pgx both have a SQLState
+// method, whatever the DriverName.
+func sqlState(err error) string {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+	return ""
+}
+
+// checkWritable checks that dir is a writable directory or, if it doesn't exist, that
+// it can be created in the closest existing parent directory.
+func checkWritable(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		break
+	}
+	f, err := os.CreateTemp(dir, ".pgembed-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
 
 // New initializes, downloads (if necessary), and starts an embedded PostgreSQL instance.
 // The first run for a specific PostgreSQL version might take time to download binaries.
-// Binaries are cached by `postgresql-embedded` typically in `~/.embed-postgres/`.
+// Binaries are cached in Config.CacheDir, `~/.theseus/postgresql/` by default.
 func New(config Config) (*EmbeddedPostgres, error) {
-	if config.Version == "" {
-		return nil, errors.New("PostgreSQL version must be specified in Config")
+	return newInstance(config, "")
+}
+
+// newInstance implements New and, if primaryConnStr is set, NewReplica: the data
+// directory is then a base backup of the primary rather than initialized with initdb.
+func newInstance(config Config, primaryConnStr string) (_ *EmbeddedPostgres, err error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Version == "" && config.BinariesPath == "" {
+		config.Version = DefaultVersion
+	}
+	if !cgoEnabled && config.BinariesPath == "" {
+		// Without cgo, nothing is downloaded: the installation on PATH is used.
+		var err error
+		if config.BinariesPath, err = systemBinariesPath(); err != nil {
+			return nil, err
+		}
 	}
 
-	var cDataDir *C.char
-	if config.DataDir != "" {
-		absDataDir, err := filepath.Abs(config.DataDir)
+	var metrics StartupMetrics
+	phaseStart := time.Now()
+	version := config.Version
+	if config.BinariesPath == "" {
+		var err error
+		if version, err = resolveVersion(config); err != nil {
+			return nil, err
+		}
+	}
+	resolved := config
+	resolved.Version = version
+
+	install, err := ensureBinaries(resolved)
+	if err != nil {
+		return nil, err
+	}
+	// Keeps PurgeCache from removing the binaries while the instance uses them.
+	releaseBinaries := install.release
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("failed to get absolute path for DataDir: %w", err)
+			releaseBinaries()
 		}
-		if err := os.MkdirAll(absDataDir, 0750); err != nil {
-			return nil, fmt.Errorf("failed to create DataDir %s: %w", absDataDir, err)
+	}()
+	binDir := filepath.Join(install.dir, version, "bin")
+	if install.trusted {
+		binDir = filepath.Join(install.dir, "bin")
+		if version, err = binariesVersion(binDir); err != nil {
+			return nil, err
 		}
-		cDataDir = C.CString(absDataDir)
-		defer C.free(unsafe.Pointer(cDataDir))
 	}
 
-	var cRuntimeDir *C.char
+	// Options understood by the Rust layer, see apply_options in rust/src/lib.rs.
+	options := url.Values{}
+	if exactVersion.MatchString(version) {
+		options.Set("version", "="+version)
+	}
+	options.Set("username", config.superuser())
+	options.Set("installation_dir", install.dir)
+	if install.trusted {
+		options.Set("trust_installation_dir", "true")
+	}
+	metrics.DownloadDuration = time.Since(phaseStart)
+	metrics.CacheHit = install.cached
+	phaseStart = time.Now()
+
+	var absRuntimeDir string
 	if config.RuntimeDir != "" {
-		absRuntimeDir, err := filepath.Abs(config.RuntimeDir)
+		absRuntimeDir, err = filepath.Abs(config.RuntimeDir)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get absolute path for RuntimeDir: %w", err)
 		}
 		if err := os.MkdirAll(absRuntimeDir, 0750); err != nil {
 			return nil, fmt.Errorf("failed to create RuntimeDir %s: %w", absRuntimeDir, err)
 		}
-		cRuntimeDir = C.CString(absRuntimeDir)
-		defer C.free(unsafe.Pointer(cRuntimeDir))
+	} else if runtime.GOOS != "windows" {
+		// Not the data directory: socket paths are limited to about 100 bytes.
+		absRuntimeDir, err = os.MkdirTemp("", "pgembed-run-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary RuntimeDir: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(absRuntimeDir)
+			}
+		}()
 	}
 
-	var cPassword *C.char
-	if config.Password != "" {
-		cPassword = C.CString(config.Password)
-		defer C.free(unsafe.Pointer(cPassword))
+	// The data directory is initialized here rather than by the Rust layer so that
+	// initdb options postgresql-embedded doesn't expose can be passed. The Rust layer
+	// then finds an initialized cluster and only starts it.
+	var absDataDir string
+	if config.DataDir != "" {
+		absDataDir, err = filepath.Abs(config.DataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for DataDir: %w", err)
+		}
+		if err := os.MkdirAll(absDataDir, 0750); err != nil {
+			return nil, fmt.Errorf("failed to create DataDir %s: %w", absDataDir, err)
+		}
+		if err := checkDataDirLock(absDataDir, config.RemoveStaleLock); err != nil {
+			return nil, err
+		}
+	} else {
+		// Removed when the instance is stopped.
+		absDataDir, err = os.MkdirTemp("", "pgembed-data-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary DataDir: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(absDataDir)
+			}
+		}()
 	}
 
-	// Call the modified Rust function which returns PgStartResult struct by value
-	cResult := C.pg_embedded_create_and_start(
-		cDataDir,
-		cRuntimeDir,
-		C.ushort(config.Port),
-		cPassword,
-	)
-
-	// Check if Rust returned an error message
-	if cResult.error_msg != nil {
-		errMsg := C.GoString(cResult.error_msg)
-		C.pg_embedded_free_string(cResult.error_msg) // Free the error message string from Rust
+	password, err := superuserPassword(absDataDir, config)
+	if err != nil {
+		return nil, err
+	}
 
-		// If pg_ptr was somehow non-null, try to stop it (defensive)
-		if cResult.pg_ptr != nil {
-			C.pg_embedded_stop(cResult.pg_ptr)
+	if primaryConnStr != "" {
+		err = baseBackup(binDir, absDataDir, primaryConnStr, password)
+	} else {
+		err = initDataDir(binDir, absDataDir, password, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreloadLibraries(binDir, config.SharedPreloadLibraries); err != nil {
+		return nil, err
+	}
+	if config.TLS != nil {
+		// Work on a copy, the generated certificate files are filled in below.
+		tlsConfig := *config.TLS
+		if tlsConfig.SelfSigned {
+			certDir := absRuntimeDir
+			if certDir == "" {
+				certDir = absDataDir
+			}
+			if err := tlsConfig.generateSelfSigned(certDir); err != nil {
+				return nil, err
+			}
 		}
-		return nil, fmt.Errorf("failed to create/start embedded PostgreSQL (from Rust): %s", errMsg)
+		if err := tlsConfig.installFiles(absDataDir); err != nil {
+			return nil, err
+		}
+		config.TLS = &tlsConfig
+	}
+	settings := config.serverSettings()
+	if absRuntimeDir != "" {
+		settings["unix_socket_directories"] = absRuntimeDir
+	}
+	var logOffset int64 // the size of the log before this start
+	if config.LogWriter != nil {
+		for name, value := range logSettings() {
+			settings[name] = value
+		}
+		if info, err := os.Stat(serverLogPath(absDataDir)); err == nil {
+			logOffset = info.Size()
+		}
+	}
+	if err := writeServerConfig(absDataDir, settings); err != nil {
+		return nil, err
+	}
+	if err := writeHBARules(absDataDir, config.HBARules); err != nil {
+		return nil, err
 	}
 
-	// If no error message, but pg_ptr is null, this is an unexpected state
-	if cResult.pg_ptr == nil {
-		panic("received null pg_ptr without error message")
+	// In whole seconds, rounded up.
+	options.Set("timeout", strconv.Itoa(int((config.startupTimeout()+time.Second-1)/time.Second)))
+	metrics.InitDuration = time.Since(phaseStart)
+	phaseStart = time.Now()
+	var instance serverInstance
+	for attempt := 0; ; attempt++ {
+		instance, err = startWithTimeout(absDataDir, absRuntimeDir, config.Port, password, options.Encode(), config.startupTimeout())
+		// A random port can be taken by someone else before the server binds it, the
+		// next attempt picks another one.
+		if err == nil || !errors.Is(err, ErrPortInUse) || config.Port != 0 || attempt >= config.StartRetries {
+			break
+		}
 	}
+	if err != nil {
+		return nil, err
+	}
+	metrics.StartDuration = time.Since(phaseStart)
 
 	// Success case
-	pg := &EmbeddedPostgres{instance: cResult.pg_ptr, config: config}
-	runtime.SetFinalizer(pg, (*EmbeddedPostgres).Stop)
+	pg := &EmbeddedPostgres{instance: instance, config: config, version: version, binDir: binDir,
+		dataDir: absDataDir, runtimeDir: absRuntimeDir, started: time.Now(), metrics: metrics,
+		password: password, releaseBinaries: releaseBinaries}
+	if config.DataDir == "" {
+		pg.tempDirs = append(pg.tempDirs, absDataDir)
+	}
+	if config.RuntimeDir == "" && absRuntimeDir != "" {
+		pg.tempDirs = append(pg.tempDirs, absRuntimeDir)
+	}
+	if config.LogWriter != nil {
+		pg.logTailer = tailLog(serverLogPath(absDataDir), logOffset, config.LogWriter)
+	}
+	if !config.DisableFinalizer {
+		runtime.SetFinalizer(pg, finalize)
+	}
+
+	if err := pg.createDatabases(config.Databases); err != nil {
+		return nil, errors.Join(err, pg.Stop())
+	}
 	return pg, nil
 }
 
-// Stop shuts down and cleans up the embedded PostgreSQL instance.
+// StartupMetrics is how long the phases of New took, see EmbeddedPostgres.StartupMetrics.
+type StartupMetrics struct {
+	// DownloadDuration is the time spent resolving the version and downloading the
+	// binaries, close to 0 when they are cached.
+	DownloadDuration time.Duration
+	// CacheHit reports whether the binaries were already in the cache, or BinariesPath,
+	// rather than downloaded, e.g. to detect a CI cache that isn't restored.
+	CacheHit bool
+	// InitDuration is the time spent initializing and configuring the data directory,
+	// with initdb unless it was initialized already.
+	InitDuration time.Duration
+	// StartDuration is the time spent starting the server, including retries.
+	StartDuration time.Duration
+}
+
+// StartupMetrics returns how long the phases of New took, e.g. to decide whether sharing
+// an instance between tests is worth it.
+func (pg *EmbeddedPostgres) StartupMetrics() StartupMetrics {
+	return pg.metrics
+}
+
+// ShutdownMode is how the server shuts down, see StopMode.
+type ShutdownMode string
+
+const (
+	// ShutdownSmart waits for all clients to disconnect.
+	ShutdownSmart ShutdownMode = "smart"
+	// ShutdownFast disconnects clients and shuts down cleanly, with a checkpoint.
+	ShutdownFast ShutdownMode = "fast"
+	// ShutdownImmediate aborts the server processes without a checkpoint, which
+	// requires crash recovery on the next start.
+	ShutdownImmediate ShutdownMode = "immediate"
+)
+
+// ErrPortInUse is returned by New when the server can't listen on its port because
+// another process uses it.
+var ErrPortInUse = errors.New("port is already in use")
+
+// startServer starts the server, through the Rust layer or, without cgo, with pg_ctl. It is a variable so that
+// tests can simulate failures.
+var startServer = start
+
+// ErrStartupTimeout is returned by New when the server doesn't start within
+// Config.StartupTimeout.
+var ErrStartupTimeout = errors.New("PostgreSQL startup timed out")
+
+// defaultStartupTimeout is used when Config.StartupTimeout is 0.
+const defaultStartupTimeout = 30 * time.Second
+
+// startupTimeout returns how long starting the server may take.
+func (c Config) startupTimeout() time.Duration {
+	if c.StartupTimeout <= 0 {
+		return defaultStartupTimeout
+	}
+	return c.StartupTimeout
+}
+
+// startWithTimeout starts the server, giving up after timeout. The Rust layer enforces
+// the timeout too, but it can't be interrupted: if it returns late anyway, the server
+// is stopped in the background.
+func startWithTimeout(dataDir, runtimeDir string, port uint16, password, options string, timeout time.Duration) (serverInstance, error) {
+	type result struct {
+		instance serverInstance
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		instance, err := startServer(dataDir, runtimeDir, port, password, options)
+		done <- result{instance, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.instance, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.instance != nil {
+				stopServer(r.instance)
+			}
+		}()
+		return nil, fmt.Errorf("%w: the server did not start within %s", ErrStartupTimeout, timeout)
+	}
+}
+
+// warningOutput is where warnings about misuse, such as a missing Stop, are written.
+var warningOutput io.Writer = os.Stderr
+
+// finalize stops an instance that is garbage collected without having been stopped,
+// warning about it: when that happens is unpredictable, the instance should be stopped
+// explicitly.
+func finalize(pg *EmbeddedPostgres) {
+	if pg.instance == nil {
+		return
+	}
+	fmt.Fprintf(warningOutput, "pgembed: WARNING: the instance with data directory %s was garbage collected without calling Stop, stopping it\n", pg.dataDir)
+	if err := pg.Stop(); err != nil {
+		fmt.Fprintf(warningOutput, "pgembed: WARNING: %v\n", err)
+	}
+}
+
+// Stop shuts down and cleans up the embedded PostgreSQL instance, using ShutdownFast.
 // It's safe to call Stop multiple times.
-// This method is also registered as a finalizer for the EmbeddedPostgres struct.
+// An instance that is garbage collected without having been stopped is stopped, with a
+// warning written to stderr, unless Config.DisableFinalizer is set.
 func (pg *EmbeddedPostgres) Stop() error {
+	return pg.StopMode(ShutdownFast)
+}
+
+// StopMode shuts down and cleans up the embedded PostgreSQL instance using the given
+// shutdown mode. It's safe to call StopMode multiple times.
+func (pg *EmbeddedPostgres) StopMode(mode ShutdownMode) error {
+	if mode != ShutdownSmart && mode != ShutdownFast && mode != ShutdownImmediate {
+		return fmt.Errorf("unsupported ShutdownMode %q", mode)
+	}
 	if pg.instance == nil {
 		return nil // Already stopped or never started
 	}
@@ -161,95 +693,505 @@ func (pg *EmbeddedPostgres) Stop() error {
 	// However, the finalizer is called on pg itself, so `pg` won't be nil here.
 	// The primary concern is `pg.instance`.
 
-	stopped := C.pg_embedded_stop(pg.instance)
+	pg.closeDBs()
+
+	// The Rust layer always uses the fast mode. For the others, the server is stopped
+	// with pg_ctl first and the Rust layer then only cleans up, failing to stop it again.
+	var stopErr error
+	if mode != ShutdownFast {
+		stopErr = pg.runTool("pg_ctl", "stop", "--pgdata="+pg.dataDir, "--mode="+string(mode), "--wait")
+	}
+
+	stopped := stopServer(pg.instance)
 	pg.instance = nil // Mark as stopped regardless of C call result to prevent reuse
 
 	// Remove the finalizer to prevent it from running again
 	runtime.SetFinalizer(pg, nil)
 
-	if !bool(stopped) {
-		return errors.New("failed to stop embedded PostgreSQL instance, or it was already stopped by Rust drop")
+	if mode != ShutdownFast {
+		stopped = stopErr == nil
+	}
+	if pg.logTailer != nil {
+		pg.logTailer.close()
+		pg.logTailer = nil
+	}
+	pg.releaseBinaries()
+	if !stopped {
+		// Make sure the server doesn't outlive the failed stop, holding on to the port.
+		return errors.Join(
+			errors.New("failed to stop embedded PostgreSQL instance, or it was already stopped by Rust drop"),
+			stopErr,
+			CleanupOrphans(pg.dataDir),
+		)
 	}
 
+	// The Rust layer removes a temporary data directory already, pg_ctl doesn't.
+	for _, dir := range pg.tempDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove temporary directory: %w", err)
+		}
+	}
 	return nil
 }
 
-// ConnectionString returns a libpq-compatible connection string for the given database name.
-// If dbName is empty, "postgres" is typically used as the default database.
-func (pg *EmbeddedPostgres) ConnectionString(dbName string) (string, error) {
+// CACertPEM returns the PEM encoded certificate of the CA that signed the server
+// certificate: the generated certificate itself with TLSConfig.SelfSigned, otherwise the
+// contents of TLSConfig.CAFile. Clients can add it to their trusted roots.
+func (pg *EmbeddedPostgres) CACertPEM() ([]byte, error) {
+	if pg.config.TLS == nil || pg.config.TLS.CAFile == "" {
+		return nil, errors.New("TLS is not configured with a CA certificate")
+	}
+	return os.ReadFile(pg.config.TLS.CAFile)
+}
+
+// superuser returns the name of the superuser.
+func (c Config) superuser() string {
+	if c.SuperuserName == "" {
+		return "postgres"
+	}
+	return c.SuperuserName
+}
+
+// ConnectionParams are the parameters to connect to a database of an instance, see
+// EmbeddedPostgres.ConnectionParams.
+type ConnectionParams struct {
+	Host     string
+	Port     uint16
+	User     string
+	Password string
+	Database string
+	// Params are the other connection parameters, e.g. sslmode.
+	Params url.Values
+}
+
+// url returns the parameters as a URL with the given scheme, escaping the user and
+// password.
+func (p ConnectionParams) url(scheme string) (string, error) {
+	u := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(p.User, p.Password),
+		Host:   net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port))),
+		Path:   "/" + p.Database,
+	}
+	if p.Password == "" {
+		// An empty password in the URL would take precedence over PGPASSWORD.
+		u.User = url.User(p.User)
+	}
+	return withParams(u.String(), p.Params)
+}
+
+// connectionParams returns the connection string parameters derived from the config.
+func (c Config) connectionParams() (url.Values, error) {
+	params, err := c.sslParams()
+	if err != nil {
+		return nil, err
+	}
+	if c.ApplicationName != "" {
+		params.Set("application_name", c.ApplicationName)
+	}
+	if c.ConnectTimeout > 0 {
+		seconds := (c.ConnectTimeout + time.Second - 1) / time.Second
+		params.Set("connect_timeout", strconv.Itoa(int(seconds)))
+	}
+	if settings := c.sessionSettings(); len(settings) > 0 {
+		params.Set("options", serverOptions(settings))
+	}
+	return params, nil
+}
+
+// sessionSettings returns the server settings connection strings set for their
+// sessions with the options parameter.
+func (c Config) sessionSettings() [][2]string {
+	var settings [][2]string
+	if c.StatementTimeout > 0 {
+		ms := (c.StatementTimeout + time.Millisecond - 1) / time.Millisecond
+		settings = append(settings, [2]string{"statement_timeout", strconv.FormatInt(int64(ms), 10)})
+	}
+	if c.SearchPath != "" {
+		settings = append(settings, [2]string{"search_path", c.SearchPath})
+	}
+	return settings
+}
+
+// serverOptions returns the options connection parameter setting the given server
+// settings for the session, e.g. "-c statement_timeout=1000".
+func serverOptions(settings [][2]string) string {
+	// Options are separated by spaces, which must be escaped with backslashes in values.
+	escaper := strings.NewReplacer(`\`, `\\`, " ", `\ `)
+	options := make([]string, len(settings))
+	for i, setting := range settings {
+		options[i] = "-c " + setting[0] + "=" + escaper.Replace(setting[1])
+	}
+	return strings.Join(options, " ")
+}
+
+// jdbcURL returns the parameters as a URL for the PostgreSQL JDBC driver.
+func (p ConnectionParams) jdbcURL() string {
+	query := url.Values{}
+	query.Set("user", p.User)
+	query.Set("password", p.Password)
+	// The driver verifies the server certificate whenever ssl is true, unless sslmode
+	// is also set, which takes precedence and uses the same values as libpq.
+	mode := p.Params.Get("sslmode")
+	if mode == "" {
+		mode = "disable"
+	}
+	query.Set("ssl", strconv.FormatBool(mode != "disable"))
+	query.Set("sslmode", mode)
+	if rootCert := p.Params.Get("sslrootcert"); rootCert != "" {
+		query.Set("sslrootcert", rootCert)
+	}
+	if name := p.Params.Get("application_name"); name != "" {
+		query.Set("ApplicationName", name)
+	}
+	if timeout := p.Params.Get("connect_timeout"); timeout != "" {
+		query.Set("connectTimeout", timeout)
+	}
+	if options := p.Params.Get("options"); options != "" {
+		query.Set("options", options)
+	}
+	hostPort := net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port)))
+	return "jdbc:postgresql://" + hostPort + "/" + url.PathEscape(p.Database) + "?" + query.Encode()
+}
+
+// ConnectionParams returns the parameters to connect to the given database as the
+// superuser, which ConnectionString and URL are built from.
+// If dbName is empty, the "postgres" maintenance database is used.
+func (pg *EmbeddedPostgres) ConnectionParams(dbName string) (ConnectionParams, error) {
 	if pg.instance == nil {
-		return "", errors.New("instance is not running or has been stopped")
+		return ConnectionParams{}, errors.New("instance is not running or has been stopped")
 	}
 	if dbName == "" {
 		dbName = "postgres" // Default database
 	}
+	port, err := pg.port()
+	if err != nil {
+		return ConnectionParams{}, err
+	}
+	params, err := pg.config.connectionParams()
+	if err != nil {
+		return ConnectionParams{}, err
+	}
+	host := pg.config.Host
+	if host == "" {
+		host = "localhost"
+	}
+	return ConnectionParams{
+		Host:     host,
+		Port:     port,
+		User:     pg.config.superuser(),
+		Password: pg.password,
+		Database: dbName,
+		Params:   params,
+	}, nil
+}
+
+// ConnectionString returns a libpq-compatible connection string for the given database name,
+// connecting as the superuser.
+// If dbName is empty, the "postgres" maintenance database is used.
+func (pg *EmbeddedPostgres) ConnectionString(dbName string) (string, error) {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return "", err
+	}
+	return params.url("postgresql")
+}
+
+// ConnectionStringWithSearchPath is ConnectionString with searchPath as the search_path
+// of its connections instead of Config.SearchPath, e.g. to connect to the schema of a
+// tenant. If searchPath is empty, the server default is used.
+func (pg *EmbeddedPostgres) ConnectionStringWithSearchPath(dbName, searchPath string) (string, error) {
+	config := pg.config
+	config.SearchPath = searchPath
+	return pg.connectionStringWithSettings(dbName, config.sessionSettings())
+}
+
+// ReadOnlyConnectionString is ConnectionString for connections whose transactions are
+// read-only (default_transaction_read_only), so that writes fail, e.g. to test the code
+// paths using a read replica.
+func (pg *EmbeddedPostgres) ReadOnlyConnectionString(dbName string) (string, error) {
+	settings := append(pg.config.sessionSettings(), [2]string{"default_transaction_read_only", "on"})
+	return pg.connectionStringWithSettings(dbName, settings)
+}
+
+// connectionStringWithSettings is ConnectionString with settings as the session
+// settings of its options parameter instead of those of the config.
+func (pg *EmbeddedPostgres) connectionStringWithSettings(dbName string, settings [][2]string) (string, error) {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return "", err
+	}
+	if len(settings) > 0 {
+		params.Params.Set("options", serverOptions(settings))
+	} else {
+		params.Params.Del("options")
+	}
+	return params.url("postgresql")
+}
 
-	cDbName := C.CString(dbName)
-	defer C.free(unsafe.Pointer(cDbName))
+// URL returns a postgres:// URL to connect to the given database as the superuser, as
+// expected in DATABASE_URL by many tools and frameworks.
+// If dbName is empty, the "postgres" maintenance database is used.
+func (pg *EmbeddedPostgres) URL(dbName string) (string, error) {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return "", err
+	}
+	return params.url("postgres")
+}
 
-	cConnStr := C.pg_embedded_get_connection_string(pg.instance, cDbName)
-	if cConnStr == nil {
-		return "", errors.New("failed to get connection string (Rust layer returned null)")
+// JDBCURL returns a URL for the PostgreSQL JDBC driver to connect to the given database
+// as the superuser, with the SSL settings of ConnectionString, e.g. for Java processes.
+// If dbName is empty, the "postgres" maintenance database is used.
+func (pg *EmbeddedPostgres) JDBCURL(dbName string) (string, error) {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return "", err
 	}
-	defer C.pg_embedded_free_string(cConnStr)
+	return params.jdbcURL(), nil
+}
+
+// SuperuserPassword returns the password of the superuser: Config.Password, or the
+// random password generated when it is empty.
+func (pg *EmbeddedPostgres) SuperuserPassword() (string, error) {
+	if pg.instance == nil {
+		return "", errors.New("instance is not running or has been stopped")
+	}
+	return pg.password, nil
+}
+
+// DataDir returns the absolute path of the data directory: Config.DataDir, or the
+// temporary directory used when it is empty.
+func (pg *EmbeddedPostgres) DataDir() string {
+	return pg.dataDir
+}
+
+// ResolvedVersion returns the exact version of the binaries New installed, e.g. "16.4.0"
+// when Config.Version is "16", or the version of the binaries in Config.BinariesPath.
+// Unlike ServerVersion, it doesn't query the server, and is also available after Stop.
+func (pg *EmbeddedPostgres) ResolvedVersion() string {
+	return pg.version
+}
 
-	return C.GoString(cConnStr) + "?sslmode=disable", nil
+// RuntimeDir returns the absolute path of the runtime directory: Config.RuntimeDir, or
+// the temporary directory used when it is empty. It is empty on Windows unless
+// Config.RuntimeDir is set.
+func (pg *EmbeddedPostgres) RuntimeDir() string {
+	return pg.runtimeDir
 }
 
-// CreateDatabase creates a new database in the embedded instance.
-// The default owner is 'postgres' if owner string is empty.
+// SocketDir returns the directory of the server's Unix-domain socket, RuntimeDir or
+// a temporary directory if it is empty. Clients connect through it with host set to
+// the directory, e.g. "host=/tmp/pgembed-run-123 port=5432". There is no socket on
+// Windows unless RuntimeDir is set.
+func (pg *EmbeddedPostgres) SocketDir() (string, error) {
+	if pg.instance == nil {
+		return "", errors.New("instance is not running or has been stopped")
+	}
+	if pg.runtimeDir == "" {
+		return "", errors.New("no socket directory, RuntimeDir is not set")
+	}
+	return pg.runtimeDir, nil
+}
+
+// CreateDatabase creates a new database in the embedded instance, owned by owner, an
+// existing role. The default owner is the superuser if owner string is empty.
 func (pg *EmbeddedPostgres) CreateDatabase(dbName string, owner string) error {
 	if pg.instance == nil {
 		return errors.New("instance is not running or has been stopped")
 	}
-	if dbName == "" {
-		return errors.New("database name cannot be empty")
+	if err := validateIdentifier("database name", dbName); err != nil {
+		return err
 	}
 	if owner == "" {
-		owner = "postgres" // Default owner for PostgreSQL
+		owner = pg.config.superuser()
+	}
+	if err := validateIdentifier("owner", owner); err != nil {
+		return err
 	}
 
-	cDbName := C.CString(dbName)
-	defer C.free(unsafe.Pointer(cDbName))
-	// cOwner := C.CString(owner)
-	// defer C.free(unsafe.Pointer(cOwner))
-
-	if !bool(C.pg_embedded_create_database(pg.instance, cDbName)) {
-		return fmt.Errorf("failed to create database '%s' (owner parameter '%s' is no longer used by the Rust layer)", dbName, owner)
+	// Unlike createDatabase, fails if the database exists.
+	if err := pg.Exec("", DatabaseSpec{Name: dbName, Owner: owner}.sql()); err != nil {
+		return fmt.Errorf("failed to create database '%s': %w", dbName, err)
 	}
 	return nil
 }
 
+// CreateDatabaseIfNotExists creates a database owned by owner, or the superuser if
+// owner is empty, unless it already exists. The owner must be an existing role, even
+// when the database exists.
+func (pg *EmbeddedPostgres) CreateDatabaseIfNotExists(dbName string, owner string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
+	}
+	if err := validateIdentifier("database name", dbName); err != nil {
+		return err
+	}
+	if owner != "" {
+		if err := validateIdentifier("owner", owner); err != nil {
+			return err
+		}
+		exists, err := pg.roleExists(owner)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("owner '%s' of database '%s' does not exist", owner, dbName)
+		}
+	}
+	return pg.createDatabase(DatabaseSpec{Name: dbName, Owner: owner})
+}
+
 // DropDatabase drops an existing database from the embedded instance.
 func (pg *EmbeddedPostgres) DropDatabase(dbName string) error {
 	if pg.instance == nil {
 		return errors.New("instance is not running or has been stopped")
 	}
-	if dbName == "" {
-		return errors.New("database name cannot be empty")
+	if err := validateIdentifier("database name", dbName); err != nil {
+		return err
 	}
 
-	cDbName := C.CString(dbName)
-	defer C.free(unsafe.Pointer(cDbName))
+	// Open connections would prevent the database from being dropped.
+	pg.closeDB(dbName)
 
-	if !bool(C.pg_embedded_drop_database(pg.instance, cDbName)) {
-		return fmt.Errorf("failed to drop database '%s'", dbName)
+	return pg.serverDropDatabase(dbName)
+}
+
+// RenameDatabase renames the database oldName to newName, terminating the connections
+// to it first. It fails if newName already exists.
+func (pg *EmbeddedPostgres) RenameDatabase(oldName, newName string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
 	}
-	return nil
+	if err := validateIdentifier("database name", oldName); err != nil {
+		return err
+	}
+	if err := validateIdentifier("database name", newName); err != nil {
+		return err
+	}
+	exists, err := pg.DatabaseExists(newName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("failed to rename database '%s': database '%s' already exists", oldName, newName)
+	}
+
+	if err := pg.terminateConnections(oldName); err != nil {
+		return err
+	}
+	return pg.Exec("", "ALTER DATABASE "+pq.QuoteIdentifier(oldName)+" RENAME TO "+pq.QuoteIdentifier(newName))
+}
+
+// CloneDatabase creates the database dst as a copy of src, with CREATE DATABASE
+// ... TEMPLATE, terminating the connections to src first as a template can't be in use.
+// It is faster than Dump and Restore. It fails if dst already exists.
+func (pg *EmbeddedPostgres) CloneDatabase(src, dst string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
+	}
+	if err := validateIdentifier("database name", src); err != nil {
+		return err
+	}
+	if err := validateIdentifier("database name", dst); err != nil {
+		return err
+	}
+	exists, err := pg.DatabaseExists(dst)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("failed to clone database '%s': database '%s' already exists", src, dst)
+	}
+
+	if err := pg.terminateConnections(src); err != nil {
+		return err
+	}
+	return pg.Exec("", "CREATE DATABASE "+pq.QuoteIdentifier(dst)+" TEMPLATE "+pq.QuoteIdentifier(src))
+}
+
+// SetDatabaseOwner makes the existing role owner the owner of the database dbName.
+func (pg *EmbeddedPostgres) SetDatabaseOwner(dbName, owner string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
+	}
+	if err := validateIdentifier("database name", dbName); err != nil {
+		return err
+	}
+	if err := validateIdentifier("owner", owner); err != nil {
+		return err
+	}
+	exists, err := pg.roleExists(owner)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("owner '%s' of database '%s' does not exist", owner, dbName)
+	}
+	return pg.Exec("", "ALTER DATABASE "+pq.QuoteIdentifier(dbName)+" OWNER TO "+pq.QuoteIdentifier(owner))
 }
 
-// DatabaseExists checks if a database with the given name exists.
+// terminateConnections closes the pool of connections to dbName and terminates the
+// other connections to it.
+func (pg *EmbeddedPostgres) terminateConnections(dbName string) error {
+	pg.closeDB(dbName)
+	return pg.Exec("", "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", dbName)
+}
+
+// DatabaseExists checks if a database with the given name exists, see DatabasesExist
+// to check several.
 func (pg *EmbeddedPostgres) DatabaseExists(dbName string) (bool, error) {
+	exists, err := pg.DatabasesExist([]string{dbName})
+	if err != nil {
+		return false, err
+	}
+	return exists[dbName], nil
+}
+
+// DatabasesExist checks which of the databases names exist, with a single query. The
+// result has an entry for every name.
+func (pg *EmbeddedPostgres) DatabasesExist(names []string) (map[string]bool, error) {
 	if pg.instance == nil {
-		return false, errors.New("instance is not running or has been stopped")
+		return nil, errors.New("instance is not running or has been stopped")
 	}
-	if dbName == "" {
-		return false, errors.New("database name cannot be empty")
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		if err := validateIdentifier("database name", name); err != nil {
+			return nil, err
+		}
+		exists[name] = false
+	}
+	if len(names) == 0 {
+		return exists, nil
 	}
 
-	cDbName := C.CString(dbName)
-	defer C.free(unsafe.Pointer(cDbName))
+	db, err := pg.db("")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT datname FROM pg_database WHERE datname = ANY($1)", pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check databases: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to check databases: %w", err)
+		}
+		exists[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to check databases: %w", err)
+	}
+	return exists, nil
+}
 
-	exists := C.pg_embedded_database_exists(pg.instance, cDbName)
-	return bool(exists), nil
+// roleExists checks if a role with the given name exists.
+func (pg *EmbeddedPostgres) roleExists(role string) (bool, error) {
+	var exists bool
+	if err := pg.QueryRow("", "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", role).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check role '%s': %w", role, err)
+	}
+	return exists, nil
 }
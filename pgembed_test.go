@@ -1,8 +1,14 @@
 package pgembed
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,7 +33,7 @@ func TestNewAndStop(t *testing.T) {
 	defer os.RemoveAll(dataDir)
 
 	config := Config{
-		Version:    "16.0.0", // Using a known version, adjust if needed for your setup
+		Version:    DefaultVersion, // Using a known version, adjust if needed for your setup
 		DataDir:    dataDir,
 		RuntimeDir: dataDir, // Can often be the same as DataDir for tests
 		Port:       0,       // Use a random port
@@ -67,7 +73,7 @@ func TestDatabaseOperations(t *testing.T) {
 	defer os.RemoveAll(dataDir)
 
 	config := Config{
-		Version:    "16.0.0",
+		Version:    DefaultVersion,
 		DataDir:    dataDir,
 		RuntimeDir: dataDir,
 		Port:       0,
@@ -84,7 +90,10 @@ func TestDatabaseOperations(t *testing.T) {
 	}()
 
 	testDbName := "testdb_gopgembedded"
-	testOwner := "testowner" // This owner is not used by Rust but checked in Go wrapper
+	testOwner := "testowner"
+	if err := pg.Exec("", "CREATE ROLE "+testOwner); err != nil {
+		t.Fatalf("failed to create role %s: %v", testOwner, err)
+	}
 
 	// 1. Check if DB exists (should be false)
 	exists, err := pg.DatabaseExists(testDbName)
@@ -109,6 +118,16 @@ func TestDatabaseOperations(t *testing.T) {
 	if !exists {
 		t.Errorf("DatabaseExists(%s) was false after creation", testDbName)
 	}
+	var owner string
+	if err := pg.QueryRow("", "SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = $1", testDbName).Scan(&owner); err != nil {
+		t.Fatalf("failed to query the owner of %s: %v", testDbName, err)
+	}
+	if owner != testOwner {
+		t.Errorf("owner of %s = %q, want %q", testDbName, owner, testOwner)
+	}
+	if err := pg.CreateDatabase(testDbName, ""); err == nil {
+		t.Errorf("CreateDatabase(%s) of an existing database succeeded", testDbName)
+	}
 
 	// 4. Get connection string for the new database
 	connStr, err := pg.ConnectionString(testDbName)
@@ -159,6 +178,178 @@ func TestDatabaseOperations(t *testing.T) {
 	}
 }
 
+func TestCreateDatabaseIfNotExists(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	for i := 0; i < 2; i++ {
+		if err := pg.CreateDatabaseIfNotExists("idempotent", ""); err != nil {
+			t.Fatalf("CreateDatabaseIfNotExists() call %d failed: %v", i+1, err)
+		}
+	}
+	exists, err := pg.DatabaseExists("idempotent")
+	if err != nil {
+		t.Fatalf("DatabaseExists() failed: %v", err)
+	}
+	if !exists {
+		t.Error("CreateDatabaseIfNotExists() did not create the database")
+	}
+
+	if err := pg.CreateDatabaseIfNotExists("idempotent", "nobody"); err == nil {
+		t.Error("CreateDatabaseIfNotExists() with a missing owner succeeded")
+	}
+	if err := pg.CreateDatabaseIfNotExists("", ""); err == nil {
+		t.Error("CreateDatabaseIfNotExists() with an empty name succeeded")
+	}
+}
+
+func TestDatabasesExist(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("present", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	exists, err := pg.DatabasesExist([]string{"present", "postgres", "missing"})
+	if err != nil {
+		t.Fatalf("DatabasesExist() failed: %v", err)
+	}
+	want := map[string]bool{"present": true, "postgres": true, "missing": false}
+	if fmt.Sprint(exists) != fmt.Sprint(want) {
+		t.Errorf("DatabasesExist() = %v, want %v", exists, want)
+	}
+
+	if _, err := pg.DatabasesExist([]string{"present", "bad name"}); err == nil {
+		t.Error("DatabasesExist() with an invalid name succeeded")
+	}
+}
+
+func TestRenameDatabase(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("current", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	// An open connection must not prevent the rename.
+	if err := pg.Exec("current", "SELECT 1"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.RenameDatabase("current", "Archive_20240101"); err != nil {
+		t.Fatalf("RenameDatabase() failed: %v", err)
+	}
+
+	for name, want := range map[string]bool{"current": false, "Archive_20240101": true} {
+		exists, err := pg.DatabaseExists(name)
+		if err != nil {
+			t.Fatalf("DatabaseExists(%q) failed: %v", name, err)
+		}
+		if exists != want {
+			t.Errorf("DatabaseExists(%q) = %v, want %v", name, exists, want)
+		}
+	}
+
+	if err := pg.RenameDatabase("Archive_20240101", "postgres"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("RenameDatabase() to an existing name = %v, want an already exists error", err)
+	}
+}
+
+func TestCloneDatabase(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("original", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	// The open connection of the pool must not prevent the clone.
+	if err := pg.Exec("original", "CREATE TABLE users (name text); INSERT INTO users VALUES ('alice')"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.CloneDatabase("original", "migrated"); err != nil {
+		t.Fatalf("CloneDatabase() failed: %v", err)
+	}
+
+	// The clone is independent of the original.
+	if err := pg.Exec("migrated", "ALTER TABLE users ADD COLUMN email text"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	var name string
+	if err := pg.QueryRow("migrated", "SELECT name FROM users").Scan(&name); err != nil {
+		t.Fatalf("failed to query the clone: %v", err)
+	}
+	if name != "alice" {
+		t.Errorf("name = %q in the clone, want alice", name)
+	}
+	var columns int
+	if err := pg.QueryRow("original", "SELECT count(*) FROM information_schema.columns WHERE table_name = 'users'").Scan(&columns); err != nil {
+		t.Fatalf("failed to query the original: %v", err)
+	}
+	if columns != 1 {
+		t.Errorf("the original users table has %d columns, want 1", columns)
+	}
+
+	if err := pg.CloneDatabase("original", "migrated"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("CloneDatabase() to an existing name = %v, want an already exists error", err)
+	}
+}
+
+func TestSetDatabaseOwner(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE ROLE app LOGIN"); err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+	if err := pg.CreateDatabase("owned", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	if err := pg.SetDatabaseOwner("owned", "app"); err != nil {
+		t.Fatalf("SetDatabaseOwner() failed: %v", err)
+	}
+	var owner string
+	err = pg.QueryRow("", "SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = 'owned'").Scan(&owner)
+	if err != nil {
+		t.Fatalf("failed to query the owner: %v", err)
+	}
+	if owner != "app" {
+		t.Errorf("owner = %q, want app", owner)
+	}
+
+	if err := pg.SetDatabaseOwner("owned", "nobody"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("SetDatabaseOwner() to a missing role = %v, want a does not exist error", err)
+	}
+}
+
 // TestNewWithoutVersion - ensures New returns an error if version is not specified
 func TestNewWithoutVersion(t *testing.T) {
 	config := Config{
@@ -172,3 +363,560 @@ func TestNewWithoutVersion(t *testing.T) {
 		t.Fatal("New() with empty version did not return an error")
 	}
 }
+
+func TestSuperuserName(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:       DefaultVersion,
+		DataDir:       dataDir,
+		RuntimeDir:    dataDir,
+		SuperuserName: "admin",
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	connStr, err := pg.ConnectionString("")
+	if err != nil {
+		t.Fatalf("ConnectionString() failed: %v", err)
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	if err != nil {
+		t.Fatalf("sqlx.Connect(%s) failed: %v", connStr, err)
+	}
+	defer db.Close()
+
+	var user string
+	if err := db.Get(&user, "SELECT current_user"); err != nil {
+		t.Fatalf("failed to query current_user: %v", err)
+	}
+	if user != "admin" {
+		t.Errorf("current_user = %q, want admin", user)
+	}
+}
+
+func TestURLWithoutPassword(t *testing.T) {
+	params := ConnectionParams{Host: "localhost", Port: 5432, User: "postgres", Database: "app"}
+	got, err := params.url("postgresql")
+	if err != nil {
+		t.Fatalf("url() failed: %v", err)
+	}
+	// libpq would use the empty password of "postgres:@" rather than PGPASSWORD.
+	if want := "postgresql://postgres@localhost:5432/app"; got != want {
+		t.Errorf("url() = %q, want %q", got, want)
+	}
+}
+
+func TestURL(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	password := "p@ss:w/rd?#%"
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, Password: password})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	params, err := pg.ConnectionParams("")
+	if err != nil {
+		t.Fatalf("ConnectionParams() failed: %v", err)
+	}
+	if params.Password != password || params.Database != "postgres" || params.Port == 0 {
+		t.Errorf("ConnectionParams() = %+v, want the password, postgres database and port", params)
+	}
+
+	dbURL, err := pg.URL("")
+	if err != nil {
+		t.Fatalf("URL() failed: %v", err)
+	}
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatalf("URL() = %q does not parse: %v", dbURL, err)
+	}
+	if got, _ := u.User.Password(); u.Scheme != "postgres" || got != password {
+		t.Errorf("URL() = %q, want a postgres URL with the password", dbURL)
+	}
+	db, err := sqlx.Connect("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("sqlx.Connect(%s) failed: %v", dbURL, err)
+	}
+	db.Close()
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if _, err := pg.URL(""); err == nil {
+		t.Error("URL() of a stopped instance succeeded")
+	}
+}
+
+func TestApplicationNameAndConnectTimeout(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:         DefaultVersion,
+		DataDir:         dataDir,
+		RuntimeDir:      dataDir,
+		ApplicationName: "pgembed-test",
+		ConnectTimeout:  1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	connStr, err := pg.ConnectionString("")
+	if err != nil {
+		t.Fatalf("ConnectionString() failed: %v", err)
+	}
+	for _, want := range []string{"application_name=pgembed-test", "connect_timeout=2", "sslmode=disable"} {
+		if !strings.Contains(connStr, want) {
+			t.Errorf("ConnectionString() = %q, want %s", connStr, want)
+		}
+	}
+	var name string
+	if err := pg.QueryRow("", "SELECT current_setting('application_name')").Scan(&name); err != nil {
+		t.Fatalf("failed to query application_name: %v", err)
+	}
+	if name != "pgembed-test" {
+		t.Errorf("application_name = %q, want pgembed-test", name)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, StatementTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	err = pg.Exec("", "SELECT pg_sleep(5)")
+	if err == nil || !strings.Contains(err.Error(), "statement timeout") {
+		t.Errorf("Exec() of a query longer than StatementTimeout error = %v, want a statement timeout", err)
+	}
+	if err := pg.Exec("", "SELECT pg_sleep(0.01)"); err != nil {
+		t.Errorf("Exec() of a query shorter than StatementTimeout failed: %v", err)
+	}
+}
+
+func TestSearchPath(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, SearchPath: "app, public"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE SCHEMA app; CREATE SCHEMA tenant1"); err != nil {
+		t.Fatalf("failed to create schemas: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE settings (name text)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	connStr, err := pg.ConnectionStringWithSearchPath("", "tenant1")
+	if err != nil {
+		t.Fatalf("ConnectionStringWithSearchPath() failed: %v", err)
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	if err != nil {
+		t.Fatalf("sqlx.Connect(%s) failed: %v", connStr, err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE orders (id int)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	for table, want := range map[string]string{"settings": "app", "orders": "tenant1"} {
+		var schema string
+		err := pg.QueryRow("", "SELECT table_schema FROM information_schema.tables WHERE table_name = $1", table).Scan(&schema)
+		if err != nil {
+			t.Fatalf("failed to query the schema of %s: %v", table, err)
+		}
+		if schema != want {
+			t.Errorf("table %s was created in schema %q, want %q", table, schema, want)
+		}
+	}
+}
+
+func TestSuperuserPassword(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	password, err := pg.SuperuserPassword()
+	if err != nil {
+		t.Fatalf("SuperuserPassword() failed: %v", err)
+	}
+	if len(password) < 32 {
+		t.Errorf("SuperuserPassword() = %q, want a random password", password)
+	}
+	params, err := pg.ConnectionParams("")
+	if err != nil {
+		t.Fatalf("ConnectionParams() failed: %v", err)
+	}
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=postgres sslmode=disable",
+		params.Host, params.Port, params.User, password)
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect with the password: %v", err)
+	}
+	db.Close()
+}
+
+func TestReadOnlyConnectionString(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE TABLE events (name text)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	connStr, err := pg.ReadOnlyConnectionString("")
+	if err != nil {
+		t.Fatalf("ReadOnlyConnectionString() failed: %v", err)
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	if err != nil {
+		t.Fatalf("sqlx.Connect(%s) failed: %v", connStr, err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.Get(&count, "SELECT count(*) FROM events"); err != nil {
+		t.Errorf("failed to read with a read-only connection: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO events VALUES ('write')")
+	if err == nil || !strings.Contains(err.Error(), "read-only transaction") {
+		t.Errorf("INSERT with a read-only connection error = %v, want a read-only transaction error", err)
+	}
+}
+
+// lineWriter sends each write to a channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestFinalizerWarnsWithoutStop(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	warnings := make(lineWriter, 10)
+	warningOutput = warnings
+	defer func() { warningOutput = os.Stderr }()
+
+	if _, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir}); err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	timeout := time.After(30 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case warning := <-warnings:
+			if !strings.Contains(warning, "without calling Stop") {
+				t.Fatalf("unexpected warning: %s", warning)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no warning after the instance was garbage collected")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestDisableFinalizer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("CleanupOrphans uses signals, which aren't available on Windows")
+	}
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	warnings := make(lineWriter, 10)
+	warningOutput = warnings
+	defer func() { warningOutput = os.Stderr }()
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, DisableFinalizer: true})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	pid := pg.Status().PID
+	pg = nil
+	// The server is left running, stop it once the test is done.
+	defer CleanupOrphans(dataDir)
+
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case warning := <-warnings:
+			t.Fatalf("unexpected warning with DisableFinalizer: %s", warning)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if !processAlive(pid) {
+		t.Error("the instance was stopped after being garbage collected with DisableFinalizer")
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	instances := make([]*EmbeddedPostgres, 2)
+	errs := make(chan error, len(instances))
+	for i := range instances {
+		dataDir := filepath.Join(tempDir(t), strconv.Itoa(i))
+		defer os.RemoveAll(filepath.Dir(dataDir))
+
+		go func(i int) {
+			var err error
+			instances[i], err = New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+			errs <- err
+		}(i)
+	}
+	for range instances {
+		if err := <-errs; err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+	}
+	for _, pg := range instances {
+		defer pg.Stop()
+	}
+
+	first, second := instances[0], instances[1]
+	if first.Status().Port == second.Status().Port {
+		t.Fatalf("both instances listen on port %d", first.Status().Port)
+	}
+	for _, pg := range instances {
+		if err := pg.CreateDatabase("shard", ""); err != nil {
+			t.Fatalf("CreateDatabase() failed: %v", err)
+		}
+	}
+	if err := first.Exec("shard", "CREATE TABLE only_in_first (id int)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	var exists bool
+	if err := second.QueryRow("shard", "SELECT to_regclass('only_in_first') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if exists {
+		t.Error("table created in the first instance is visible in the second")
+	}
+}
+
+func TestSocketDir(t *testing.T) {
+	pg, err := New(Config{Version: DefaultVersion, Password: "secret"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	socketDir, err := pg.SocketDir()
+	if err != nil {
+		t.Fatalf("SocketDir() failed: %v", err)
+	}
+	port := pg.Status().Port
+	if _, err := os.Stat(filepath.Join(socketDir, fmt.Sprintf(".s.PGSQL.%d", port))); err != nil {
+		t.Errorf("no socket in SocketDir() %s: %v", socketDir, err)
+	}
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("host=%s port=%d user=postgres password=secret dbname=postgres sslmode=disable", socketDir, port))
+	if err != nil {
+		t.Fatalf("connecting through the socket failed: %v", err)
+	}
+	db.Close()
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if _, err := os.Stat(socketDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary SocketDir() %s still exists after Stop(): %v", socketDir, err)
+	}
+}
+
+func TestDataDirAndRuntimeDir(t *testing.T) {
+	pg, err := New(Config{Version: DefaultVersion})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if _, err := os.Stat(filepath.Join(pg.DataDir(), "PG_VERSION")); err != nil {
+		t.Errorf("DataDir() %s is not a data directory: %v", pg.DataDir(), err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(pg.RuntimeDir()); err != nil || !info.IsDir() {
+			t.Errorf("RuntimeDir() %s is not a directory: %v", pg.RuntimeDir(), err)
+		}
+	}
+	if !filepath.IsAbs(pg.DataDir()) {
+		t.Errorf("DataDir() = %q, want an absolute path", pg.DataDir())
+	}
+}
+
+func TestStartupMetrics(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	// Downloads the binaries if this is the first test.
+	first, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := first.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+
+	pg, err := New(Config{Version: DefaultVersion})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	metrics := pg.StartupMetrics()
+	if metrics.InitDuration <= 0 || metrics.StartDuration <= 0 {
+		t.Errorf("StartupMetrics() = %+v, want positive init and start durations", metrics)
+	}
+	if metrics.DownloadDuration > time.Second {
+		t.Errorf("StartupMetrics().DownloadDuration = %v with cached binaries, want about 0", metrics.DownloadDuration)
+	}
+	if !metrics.CacheHit {
+		t.Error("StartupMetrics().CacheHit = false with cached binaries")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := Config{Version: "16", DataDir: filepath.Join(dir, "data", "nested"), RuntimeDir: dir}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	invalid := Config{
+		Version:          "16.x",
+		AuthMethod:       "ident",
+		SSLMode:          "verify-full",
+		DataDir:          file,
+		Databases:        []DatabaseSpec{{Name: "bad name"}},
+		LocaleProvider:   "icu",
+		ConnectTimeout:   -time.Second,
+		StatementTimeout: -time.Second,
+	}
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Validate() of an invalid config succeeded")
+	}
+	for _, problem := range []string{"Version", "AuthMethod", "requires TLS", "not a directory", "bad name", "requires ICULocale", "ConnectTimeout", "StatementTimeout"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Validate() error doesn't report %q:\n%v", problem, err)
+		}
+	}
+
+	conflicting := Config{Version: DefaultVersion, TLS: &TLSConfig{SelfSigned: true, CertFile: file}}
+	if err := conflicting.Validate(); err == nil {
+		t.Error("Validate() of TLS with SelfSigned and CertFile succeeded")
+	}
+
+	unregistered := Config{Version: DefaultVersion, DriverName: "pgx"}
+	if err := unregistered.Validate(); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("Validate() with an unregistered driver = %v, want a not registered error", err)
+	}
+	unsupported := Config{Version: DefaultVersion, SSLMode: "prefer"}
+	if err := unsupported.Validate(); err == nil || !strings.Contains(err.Error(), "lib/pq") {
+		t.Errorf("Validate() of SSLMode prefer with lib/pq = %v, want a not supported error", err)
+	}
+}
+
+func TestValidateBinariesPath(t *testing.T) {
+	binariesPath := t.TempDir()
+	for _, tt := range []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"alone", Config{BinariesPath: binariesPath}, ""},
+		{"with Version", Config{BinariesPath: binariesPath, Version: DefaultVersion}, "Version"},
+		{"with UseDefaultVersion", Config{BinariesPath: binariesPath, UseDefaultVersion: true}, "UseDefaultVersion"},
+		{"with Offline", Config{BinariesPath: binariesPath, Offline: true}, "Offline"},
+		{"with DownloadBaseURL", Config{BinariesPath: binariesPath, DownloadBaseURL: "https://mirror.example.com"}, "DownloadBaseURL"},
+	} {
+		err := tt.config.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("Validate() of BinariesPath %s failed: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("Validate() of BinariesPath %s = %v, want an error about %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestStartupTimeout(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	_, err := New(Config{
+		Version:        DefaultVersion,
+		DataDir:        dataDir,
+		RuntimeDir:     dataDir,
+		StartupTimeout: time.Millisecond,
+	})
+	if !errors.Is(err, ErrStartupTimeout) {
+		t.Errorf("New() = %v, want ErrStartupTimeout", err)
+	}
+}
+
+func TestStartRetries(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	attempts := 0
+	startServer = func(dataDir, runtimeDir string, port uint16, password, options string) (serverInstance, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, fmt.Errorf("%w: could not bind IPv4 address", ErrPortInUse)
+		}
+		return start(dataDir, runtimeDir, port, password, options)
+	}
+	defer func() { startServer = start }()
+
+	config := Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir}
+	if _, err := New(config); !errors.Is(err, ErrPortInUse) {
+		t.Fatalf("New() without retries = %v, want ErrPortInUse", err)
+	}
+
+	attempts = 0
+	config.StartRetries = 2
+	pg, err := New(config)
+	if err != nil {
+		t.Fatalf("New() with retries failed: %v", err)
+	}
+	defer pg.Stop()
+	if attempts != 2 {
+		t.Errorf("New() started the server in %d attempts, want 2", attempts)
+	}
+}
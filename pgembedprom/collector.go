@@ -0,0 +1,68 @@
+// Package pgembedprom exports metrics of an embedded PostgreSQL instance to Prometheus.
+// It is a separate module so that the pgembed module doesn't depend on the Prometheus
+// client.
+//
+//	registry.MustRegister(pgembedprom.NewCollector(pg))
+package pgembedprom
+
+import (
+	"github.com/chirino/go-pgembed"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	upDesc = prometheus.NewDesc("pgembed_up",
+		"Whether the embedded PostgreSQL instance is running.", nil, nil)
+	uptimeDesc = prometheus.NewDesc("pgembed_uptime_seconds",
+		"Time since the embedded PostgreSQL instance was started.", nil, nil)
+	connectionsDesc = prometheus.NewDesc("pgembed_connections",
+		"Number of client connections to the embedded PostgreSQL instance.", nil, nil)
+	databasesDesc = prometheus.NewDesc("pgembed_databases",
+		"Number of databases in the embedded PostgreSQL instance, not counting templates.", nil, nil)
+)
+
+// collector collects the metrics of an instance when it is scraped.
+type collector struct {
+	pg *pgembed.EmbeddedPostgres
+}
+
+// NewCollector returns a collector of the metrics of pg: whether it is running, its
+// uptime, and its numbers of client connections and databases.
+func NewCollector(pg *pgembed.EmbeddedPostgres) prometheus.Collector {
+	return collector{pg: pg}
+}
+
+// Describe implements prometheus.Collector.
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- uptimeDesc
+	ch <- connectionsDesc
+	ch <- databasesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	status := c.pg.Status()
+	if !status.Running {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(uptimeDesc, prometheus.GaugeValue, status.Uptime.Seconds())
+
+	var connections float64
+	err := c.pg.QueryRow("", "SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'client backend'").Scan(&connections)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(connectionsDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, connections)
+	}
+
+	var databases float64
+	err = c.pg.QueryRow("", "SELECT count(*) FROM pg_database WHERE NOT datistemplate").Scan(&databases)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(databasesDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(databasesDesc, prometheus.GaugeValue, databases)
+	}
+}
@@ -0,0 +1,50 @@
+package pgembedprom
+
+import (
+	"testing"
+
+	"github.com/chirino/go-pgembed"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	pg, err := pgembed.New(pgembed.Config{Version: pgembed.DefaultVersion})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+	if err := pg.CreateDatabase("app", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector(pg)); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() failed: %v", err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+	}
+	if values["pgembed_up"] != 1 || values["pgembed_uptime_seconds"] <= 0 {
+		t.Errorf("got %v, want the instance up", values)
+	}
+	// postgres and app; the collector's own connection is a client backend.
+	if values["pgembed_databases"] != 2 || values["pgembed_connections"] < 1 {
+		t.Errorf("got %v, want 2 databases and at least 1 connection", values)
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	families, err = registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() failed: %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "pgembed_up" || families[0].GetMetric()[0].GetGauge().GetValue() != 0 {
+		t.Errorf("Gather() of a stopped instance = %v, want only pgembed_up 0", families)
+	}
+}
@@ -0,0 +1,371 @@
+// Package pgembedshared shares one embedded PostgreSQL instance between the tests of all
+// the processes using the same Dir, e.g. the test binaries of the packages of a module
+// run by go test ./..., giving each caller its own database.
+//
+//	func TestUsers(t *testing.T) {
+//		db, err := pgembedshared.Acquire()
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		defer db.Release()
+//		conn, err := sql.Open("postgres", db.ConnectionString)
+//		...
+//	}
+//
+// The instance is started by the first Acquire of any process and stopped when the last
+// database is released, by any process. The processes coordinate through files in Dir:
+// a lock file, and a state file recording the instance and how many databases each
+// process holds, so that the databases of a process that exited without releasing them
+// don't keep the instance running. Each database is cloned from a template database,
+// which Setup can prepare, e.g. by running migrations, so every caller starts from the
+// same state. Config and Setup are used by the process that starts the instance, so all
+// the processes must set the same ones, e.g. in TestMain.
+package pgembedshared
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chirino/go-pgembed"
+	"github.com/lib/pq"
+)
+
+// Config is used to start the shared instance. It must be set before the first Acquire
+// and its Version is mandatory. DataDir and RuntimeDir are ignored, the data directory
+// is in Dir.
+var Config pgembed.Config
+
+// Setup, if set, is called once after the shared instance is started to prepare the
+// template database templateDB the databases are cloned from.
+var Setup func(pg *pgembed.EmbeddedPostgres, templateDB string) error
+
+// Dir is the directory through which the processes share the instance, holding its data
+// directory. It defaults to a directory in os.TempDir() named after the module of the
+// working directory, so that the packages of a module share an instance and different
+// modules don't. It must be set before the first Acquire.
+var Dir = defaultDir()
+
+// templateDB is the database the databases returned by Acquire are cloned from.
+const templateDB = "pgembedshared_template"
+
+const (
+	lockFile  = "lock"
+	stateFile = "state.json"
+)
+
+var (
+	mu        sync.Mutex
+	shared    *pgembed.EmbeddedPostgres // the instance, if this process started it
+	sharedDSN string                    // the ConnectionString of shared in the state
+)
+
+// Database is a database of the shared instance owned by one caller of Acquire.
+type Database struct {
+	// Name of the database.
+	Name string
+	// ConnectionString connects to the database as the superuser.
+	ConnectionString string
+
+	released bool
+}
+
+// state is the content of the state file, describing the running instance, if any.
+type state struct {
+	// ConnectionString connects to the postgres database of the instance.
+	ConnectionString string `json:"connectionString,omitempty"`
+	// BinDir and DataDir are used to stop the instance with pg_ctl, from processes
+	// that didn't start it.
+	BinDir  string `json:"binDir,omitempty"`
+	DataDir string `json:"dataDir,omitempty"`
+	// Holders is the number of databases held, by process ID.
+	Holders map[int]int `json:"holders"`
+	// Counter numbers the databases.
+	Counter int `json:"counter"`
+}
+
+// Acquire returns a new database of the shared instance, starting the instance if it
+// isn't running. The database must be released with Release.
+func Acquire() (*Database, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	st, err := readState()
+	if err != nil {
+		return nil, err
+	}
+	st.prune()
+	if st.ConnectionString == "" || execSQL(st.ConnectionString, "SELECT 1") != nil {
+		if st, err = start(st); err != nil {
+			return nil, err
+		}
+	}
+
+	st.Counter++
+	name := fmt.Sprintf("pgembedshared_%d", st.Counter)
+	err = execSQL(st.ConnectionString, "CREATE DATABASE "+pq.QuoteIdentifier(name)+" TEMPLATE "+templateDB)
+	if err != nil {
+		return nil, errors.Join(err, st.stopIfUnused(), writeState(st))
+	}
+	dsn, err := databaseDSN(st.ConnectionString, name)
+	if err != nil {
+		return nil, err
+	}
+	st.Holders[os.Getpid()]++
+	if err := writeState(st); err != nil {
+		return nil, err
+	}
+	return &Database{Name: name, ConnectionString: dsn}, nil
+}
+
+// Release drops the database and stops the shared instance if no other database is
+// held, by any process. It's safe to call Release multiple times.
+func (d *Database) Release() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if d.released {
+		return nil
+	}
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.released = true
+
+	st, err := readState()
+	if err != nil {
+		return err
+	}
+	pid := os.Getpid()
+	if st.Holders[pid]--; st.Holders[pid] <= 0 {
+		delete(st.Holders, pid)
+	}
+	st.prune()
+
+	var dropErr error
+	if st.ConnectionString != "" {
+		// Open connections would prevent the database from being dropped.
+		dropErr = execSQL(st.ConnectionString, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1", d.Name)
+		if dropErr == nil {
+			dropErr = execSQL(st.ConnectionString, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(d.Name))
+		}
+	}
+	return errors.Join(dropErr, st.stopIfUnused(), writeState(st))
+}
+
+// start starts the instance, replacing the unreachable one of st, and prepares the
+// template database.
+func start(st state) (state, error) {
+	if shared != nil {
+		shared.Stop() // The instance was stopped by another process or crashed.
+		shared, sharedDSN = nil, ""
+	}
+	if st.DataDir != "" {
+		stopServer(st) // In case it is running but not accepting connections.
+	}
+
+	config := Config
+	config.DataDir = filepath.Join(Dir, "data")
+	config.RuntimeDir = config.DataDir
+	// The instance is used by other processes after this one exits.
+	config.DisableFinalizer = true
+	// The databases of the previous instance are no longer held.
+	if err := os.RemoveAll(config.DataDir); err != nil {
+		return state{}, fmt.Errorf("failed to remove the previous shared instance: %w", err)
+	}
+	pg, err := pgembed.New(config)
+	if err != nil {
+		return state{}, fmt.Errorf("failed to start the shared instance: %w", err)
+	}
+	if err := setup(pg); err != nil {
+		pg.Stop()
+		return state{}, err
+	}
+	dsn, err := pg.ConnectionString("")
+	if err != nil {
+		pg.Stop()
+		return state{}, err
+	}
+	binDir, err := pg.BinDir()
+	if err != nil {
+		pg.Stop()
+		return state{}, err
+	}
+	shared, sharedDSN = pg, dsn
+	return state{ConnectionString: dsn, BinDir: binDir, DataDir: pg.DataDir(), Holders: st.Holders, Counter: st.Counter}, nil
+}
+
+// setup creates the template database and calls Setup.
+func setup(pg *pgembed.EmbeddedPostgres) error {
+	if err := pg.CreateDatabase(templateDB, ""); err != nil {
+		return err
+	}
+	if Setup != nil {
+		if err := Setup(pg, templateDB); err != nil {
+			return fmt.Errorf("failed to set up the template database: %w", err)
+		}
+	}
+	// Connections left open by Setup would prevent the template from being cloned.
+	return pg.Exec("", "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1", templateDB)
+}
+
+// stopIfUnused stops the instance of st if no database is held, and forgets it.
+func (s *state) stopIfUnused() error {
+	if len(s.Holders) > 0 || s.DataDir == "" {
+		return nil
+	}
+	var err error
+	if shared != nil && sharedDSN == s.ConnectionString {
+		err = shared.Stop()
+	} else {
+		err = stopServer(*s)
+	}
+	shared, sharedDSN = nil, ""
+	*s = state{Holders: map[int]int{}}
+	return err
+}
+
+// stopServer stops the instance of s with pg_ctl, as it may have been started by
+// another process.
+func stopServer(s state) error {
+	cmd := exec.Command(filepath.Join(s.BinDir, "pg_ctl"), "stop", "--pgdata="+s.DataDir, "--mode=fast", "--wait")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stop the shared instance: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// prune forgets the databases held by processes that exited.
+func (s *state) prune() {
+	for pid := range s.Holders {
+		if !processAlive(pid) {
+			delete(s.Holders, pid)
+		}
+	}
+}
+
+// readState reads the state file, returning an empty state if there is none.
+func readState() (state, error) {
+	st := state{Holders: map[int]int{}}
+	content, err := os.ReadFile(filepath.Join(Dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed to read the shared instance state: %w", err)
+	}
+	if err := json.Unmarshal(content, &st); err != nil {
+		return st, fmt.Errorf("failed to read the shared instance state: %w", err)
+	}
+	if st.Holders == nil {
+		st.Holders = map[int]int{}
+	}
+	return st, nil
+}
+
+// writeState writes the state file. It holds the password of the superuser.
+func writeState(st state) error {
+	content, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(Dir, stateFile), content, 0600); err != nil {
+		return fmt.Errorf("failed to write the shared instance state: %w", err)
+	}
+	return nil
+}
+
+// lock acquires the lock file of Dir. It holds the ID of the process owning it, so that
+// the lock of a process that exited without releasing it is taken over.
+func lock() (func(), error) {
+	if err := os.MkdirAll(Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", Dir, err)
+	}
+	path := filepath.Join(Dir, lockFile)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock %s: %w", path, err)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock %s: %w", path, err)
+		}
+		// The content is empty until the owner has written its ID.
+		if content, err := os.ReadFile(path); err == nil {
+			if pid, err := strconv.Atoi(string(content)); err == nil && !processAlive(pid) {
+				os.Remove(path)
+				continue
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// execSQL runs query on a new connection to dsn.
+func execSQL(dsn, query string, args ...any) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+// databaseDSN returns dsn, a URL, with its database replaced with name.
+func databaseDSN(dsn, name string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection string of the shared instance: %w", err)
+	}
+	u.Path = "/" + name
+	return u.String(), nil
+}
+
+// defaultDir returns the default Dir, named after the module containing the working
+// directory, or the working directory itself outside of a module.
+func defaultDir() string {
+	root, err := os.Getwd()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "pgembedshared")
+	}
+	for dir := root; ; {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			root = dir
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(os.TempDir(), "pgembedshared-"+hex.EncodeToString(sum[:6]))
+}
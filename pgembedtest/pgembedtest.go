@@ -0,0 +1,58 @@
+// Package pgembedtest starts embedded PostgreSQL instances for tests, stopped when the
+// test completes.
+//
+//	func TestUsers(t *testing.T) {
+//		pg := pgembedtest.New(t, pgembed.Config{Version: pgembed.DefaultVersion},
+//			pgembedtest.WithServerLogs(t))
+//		dsn, err := pg.ConnectionString("")
+//		...
+//	}
+package pgembedtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chirino/go-pgembed"
+)
+
+// Option changes the config of the instance started by New.
+type Option func(config *pgembed.Config)
+
+// WithServerLogs forwards the lines of the server log to tb.Log, so that they are shown
+// under the test when it fails, or with go test -v. Without it, the server log isn't
+// shown.
+func WithServerLogs(tb testing.TB) Option {
+	return func(config *pgembed.Config) {
+		config.LogWriter = logWriter{tb}
+	}
+}
+
+// logWriter logs each write, a line of the server log, with tb.Log.
+type logWriter struct {
+	tb testing.TB
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.tb.Log(strings.TrimRight(string(p), "\r\n"))
+	return len(p), nil
+}
+
+// New starts an instance with config, changed by opts, failing the test if it can't,
+// and stops it when the test and its subtests complete.
+func New(tb testing.TB, config pgembed.Config, opts ...Option) *pgembed.EmbeddedPostgres {
+	tb.Helper()
+	for _, opt := range opts {
+		opt(&config)
+	}
+	pg, err := pgembed.New(config)
+	if err != nil {
+		tb.Fatalf("failed to start embedded PostgreSQL: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := pg.Stop(); err != nil {
+			tb.Errorf("failed to stop embedded PostgreSQL: %v", err)
+		}
+	})
+	return pg
+}
@@ -0,0 +1,51 @@
+package pgembedtest
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chirino/go-pgembed"
+)
+
+// recordingTB records the messages logged by a test.
+type recordingTB struct {
+	testing.TB
+
+	mu   sync.Mutex
+	logs []string
+}
+
+func (tb *recordingTB) Log(args ...any) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, arg := range args {
+		tb.logs = append(tb.logs, arg.(string))
+	}
+}
+
+func TestWithServerLogs(t *testing.T) {
+	recorder := &recordingTB{TB: t}
+	pg := New(t, pgembed.Config{Version: pgembed.DefaultVersion}, WithServerLogs(recorder))
+
+	if err := pg.Exec("", "SELECT 1/0"); err == nil {
+		t.Fatal("Exec() of a division by zero succeeded")
+	}
+	// Stop copies the rest of the log.
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	found := false
+	for _, line := range recorder.logs {
+		if strings.HasSuffix(line, "\n") {
+			t.Errorf("logged line %q ends with a newline", line)
+		}
+		found = found || strings.Contains(line, "division by zero")
+	}
+	if !found {
+		t.Errorf("the error is not in the logged server log:\n%s", strings.Join(recorder.logs, "\n"))
+	}
+}
@@ -0,0 +1,116 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// privileges are the privileges Grant and Revoke accept, by object type.
+var privileges = map[string]map[string]bool{
+	"DATABASE": {"CREATE": true, "CONNECT": true, "TEMPORARY": true, "TEMP": true, "ALL": true, "ALL PRIVILEGES": true},
+	"SCHEMA":   {"CREATE": true, "USAGE": true, "ALL": true, "ALL PRIVILEGES": true},
+	"TABLE": {"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "TRUNCATE": true,
+		"REFERENCES": true, "TRIGGER": true, "ALL": true, "ALL PRIVILEGES": true},
+}
+
+// Grant grants privilege, or a comma separated list of privileges, e.g. "SELECT" or
+// "SELECT, INSERT", on the object of objectType "DATABASE", "SCHEMA" or "TABLE" named
+// objectName to role, in the database dbName. Table names may be schema qualified.
+// role may be "PUBLIC" to grant the privileges to all roles.
+func (pg *EmbeddedPostgres) Grant(dbName, privilege, objectType, objectName, role string) error {
+	target, err := privilegeTarget(privilege, objectType, objectName, role)
+	if err != nil {
+		return err
+	}
+	if err := pg.Exec(dbName, "GRANT "+target.privileges+" ON "+target.object+" TO "+target.role); err != nil {
+		return fmt.Errorf("failed to grant %s on %s to '%s': %w", target.privileges, target.object, role, err)
+	}
+	return nil
+}
+
+// Revoke revokes privileges granted with Grant, see Grant for the arguments.
+func (pg *EmbeddedPostgres) Revoke(dbName, privilege, objectType, objectName, role string) error {
+	target, err := privilegeTarget(privilege, objectType, objectName, role)
+	if err != nil {
+		return err
+	}
+	if err := pg.Exec(dbName, "REVOKE "+target.privileges+" ON "+target.object+" FROM "+target.role); err != nil {
+		return fmt.Errorf("failed to revoke %s on %s from '%s': %w", target.privileges, target.object, role, err)
+	}
+	return nil
+}
+
+// SetRolePassword sets the password of role, which is stored with the password_encoding
+// of Config.AuthMethod. The password of the superuser can't be changed, as the
+// instance keeps connecting with the one it was started with.
+func (pg *EmbeddedPostgres) SetRolePassword(role, password string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
+	}
+	if err := validateIdentifier("role name", role); err != nil {
+		return err
+	}
+	if role == pg.config.superuser() {
+		return fmt.Errorf("the password of the superuser '%s' can't be changed", role)
+	}
+	if err := pg.Exec("", "ALTER ROLE "+pq.QuoteIdentifier(role)+" WITH PASSWORD "+pq.QuoteLiteral(password)); err != nil {
+		return fmt.Errorf("failed to set the password of role '%s': %w", role, err)
+	}
+	return nil
+}
+
+// grantTarget is the validated SQL of the privileges, object and role of a GRANT or
+// REVOKE.
+type grantTarget struct {
+	privileges string // e.g. "SELECT, INSERT"
+	object     string // e.g. `TABLE "public"."users"`
+	role       string // e.g. `"reader"` or PUBLIC
+}
+
+// privilegeTarget validates the arguments of Grant and Revoke against the allowed
+// privileges and identifiers, so that they can't inject into the SQL they are used in.
+func privilegeTarget(privilege, objectType, objectName, role string) (grantTarget, error) {
+	objectType = strings.ToUpper(objectType)
+	allowed, ok := privileges[objectType]
+	if !ok {
+		return grantTarget{}, fmt.Errorf("unsupported object type %q: must be DATABASE, SCHEMA or TABLE", objectType)
+	}
+	var privs []string
+	for _, priv := range strings.Split(privilege, ",") {
+		priv = strings.ToUpper(strings.Join(strings.Fields(priv), " "))
+		if !allowed[priv] {
+			return grantTarget{}, fmt.Errorf("unsupported privilege %q on %s", priv, objectType)
+		}
+		privs = append(privs, priv)
+	}
+
+	names := []string{objectName}
+	if objectType == "TABLE" {
+		names = strings.SplitN(objectName, ".", 2)
+	}
+	for _, name := range names {
+		if err := validateIdentifier(strings.ToLower(objectType)+" name", name); err != nil {
+			return grantTarget{}, err
+		}
+	}
+	if err := validateIdentifier("role name", role); err != nil {
+		return grantTarget{}, err
+	}
+
+	target := grantTarget{
+		privileges: strings.Join(privs, ", "),
+		object:     objectType + " " + quoteQualifiedName(objectName),
+		role:       pq.QuoteIdentifier(role),
+	}
+	if objectType != "TABLE" {
+		target.object = objectType + " " + pq.QuoteIdentifier(objectName)
+	}
+	// PUBLIC is a keyword, quoted it would name a role.
+	if strings.EqualFold(role, "PUBLIC") {
+		target.role = "PUBLIC"
+	}
+	return target, nil
+}
@@ -0,0 +1,137 @@
+package pgembed
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestGrantAndRevoke(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE ROLE reader LOGIN PASSWORD 'secret'; CREATE TABLE books (title text)"); err != nil {
+		t.Fatalf("failed to set up: %v", err)
+	}
+	if err := pg.Grant("", "select", "table", "public.books", "reader"); err != nil {
+		t.Fatalf("Grant() failed: %v", err)
+	}
+
+	params, err := pg.ConnectionParams("")
+	if err != nil {
+		t.Fatalf("ConnectionParams() failed: %v", err)
+	}
+	params.User, params.Password = "reader", "secret"
+	connStr, err := params.url("postgresql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	if err != nil {
+		t.Fatalf("sqlx.Connect(%s) failed: %v", connStr, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("SELECT * FROM books"); err != nil {
+		t.Errorf("reader can't read after Grant(): %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO books VALUES ('Dune')"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("reader write error = %v, want permission denied", err)
+	}
+
+	if err := pg.Revoke("", "SELECT", "TABLE", "books", "reader"); err != nil {
+		t.Fatalf("Revoke() failed: %v", err)
+	}
+	if _, err := db.Exec("SELECT * FROM books"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("reader read error after Revoke() = %v, want permission denied", err)
+	}
+}
+
+func TestPrivilegeTarget(t *testing.T) {
+	target, err := privilegeTarget("select,  insert", "table", "app.users", "PUBLIC")
+	if err != nil {
+		t.Fatalf("privilegeTarget() failed: %v", err)
+	}
+	if want := (grantTarget{privileges: "SELECT, INSERT", object: `TABLE "app"."users"`, role: "PUBLIC"}); target != want {
+		t.Errorf("privilegeTarget() = %+v, want %+v", target, want)
+	}
+	target, err = privilegeTarget("all privileges", "DATABASE", "App", "Reader")
+	if err != nil {
+		t.Errorf("privilegeTarget() of ALL PRIVILEGES failed: %v", err)
+	}
+	if want := (grantTarget{privileges: "ALL PRIVILEGES", object: `DATABASE "App"`, role: `"Reader"`}); target != want {
+		t.Errorf("privilegeTarget() = %+v, want %+v", target, want)
+	}
+
+	for _, args := range [][4]string{
+		{"SELECT; DROP TABLE users", "TABLE", "users", "reader"},
+		{"USAGE", "TABLE", "users", "reader"},
+		{"SELECT", "FUNCTION", "users", "reader"},
+		{"SELECT", "TABLE", "users; --", "reader"},
+		{"SELECT", "TABLE", "users", "reader, admin"},
+		{"CONNECT", "DATABASE", "app.main", "reader"},
+	} {
+		if _, err := privilegeTarget(args[0], args[1], args[2], args[3]); err == nil {
+			t.Errorf("privilegeTarget(%q, %q, %q, %q) succeeded", args[0], args[1], args[2], args[3])
+		}
+	}
+}
+
+func TestSetRolePassword(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, AuthMethod: "scram-sha-256"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE ROLE app LOGIN"); err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+	connect := func(password string) error {
+		params, err := pg.ConnectionParams("")
+		if err != nil {
+			return err
+		}
+		params.User, params.Password = "app", password
+		connStr, err := params.url("postgresql")
+		if err != nil {
+			return err
+		}
+		db, err := sqlx.Connect("postgres", connStr)
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}
+
+	if err := pg.SetRolePassword("app", "it's-first"); err != nil {
+		t.Fatalf("SetRolePassword() failed: %v", err)
+	}
+	if err := connect("it's-first"); err != nil {
+		t.Errorf("failed to connect with the password: %v", err)
+	}
+	if err := pg.SetRolePassword("app", "second"); err != nil {
+		t.Fatalf("SetRolePassword() failed: %v", err)
+	}
+	if err := connect("second"); err != nil {
+		t.Errorf("failed to connect with the rotated password: %v", err)
+	}
+	if err := connect("it's-first"); err == nil {
+		t.Error("connected with the old password after rotating it")
+	}
+
+	if err := pg.SetRolePassword("postgres", "other"); err == nil {
+		t.Error("SetRolePassword() of the superuser succeeded")
+	}
+}
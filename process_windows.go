@@ -0,0 +1,46 @@
+//go:build windows
+
+package pgembed
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// processAlive reports whether a process with the given ID exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	const processQueryLimitedInformation = 0x1000
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	const stillActive = 259
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// postgresAlive reports whether a postgres process with the given ID exists. The
+// process name isn't checked, any live process is assumed to be postgres.
+func postgresAlive(pid int) bool {
+	return processAlive(pid)
+}
+
+// terminateProcess does nothing, signals aren't available on Windows.
+func terminateProcess(pid int, grace time.Duration) error {
+	return nil
+}
+
+// raiseSignal exits the process with status 1, as signals can't be sent on Windows and
+// the signals programs receive there, such as Ctrl-C, terminate them.
+func raiseSignal(sig os.Signal) {
+	os.Exit(1)
+}
@@ -0,0 +1,100 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// NewReplica starts a hot standby of primary, which must be running: its data
+// directory is a base backup of the primary taken with pg_basebackup, and it then
+// streams the changes of the primary, accepting read-only queries.
+//
+// The replica has the superuser and password of the primary, config.SuperuserName,
+// Password and AuthMethod are ignored. It uses the binaries of the primary unless
+// config.Version or config.BinariesPath is set, to the same major version. Databases
+// can't be set, they are created on the primary. When config.DataDir already holds a
+// cluster, it is started as is.
+func NewReplica(primary *EmbeddedPostgres, config Config) (*EmbeddedPostgres, error) {
+	if primary.instance == nil {
+		return nil, errors.New("primary instance is not running or has been stopped")
+	}
+	if len(config.Databases) > 0 {
+		return nil, errors.New("a replica is read-only, create the Databases on the primary")
+	}
+	// The password, the same on the replica, is passed to pg_basebackup separately.
+	primaryConnStr, err := primary.toolDSN("")
+	if err != nil {
+		return nil, err
+	}
+
+	config.SuperuserName = primary.config.SuperuserName
+	config.Password = primary.password
+	config.AuthMethod = primary.config.AuthMethod
+	if config.Version == "" && config.BinariesPath == "" {
+		if primary.config.BinariesPath != "" {
+			config.BinariesPath = primary.config.BinariesPath
+		} else {
+			config.Version = primary.version
+		}
+	}
+	return newInstance(config, primaryConnStr)
+}
+
+// requireLogicalWAL checks that wal_level is logical, which logical decoding and
+// replication require.
+func (pg *EmbeddedPostgres) requireLogicalWAL() error {
+	level, err := pg.ShowSetting("wal_level")
+	if err != nil {
+		return err
+	}
+	if level != "logical" {
+		return fmt.Errorf("logical replication requires wal_level logical, the server has %s: set Config.WALLevel", level)
+	}
+	return nil
+}
+
+// CreateLogicalSlot creates the logical replication slot slot in dbName with
+// pg_create_logical_replication_slot, decoding changes with the output plugin plugin,
+// "pgoutput" if empty. The server must run with wal_level logical.
+func (pg *EmbeddedPostgres) CreateLogicalSlot(dbName, slot, plugin string) error {
+	if err := validateIdentifier("replication slot", slot); err != nil {
+		return err
+	}
+	if plugin == "" {
+		plugin = "pgoutput"
+	}
+	if err := pg.requireLogicalWAL(); err != nil {
+		return err
+	}
+	if err := pg.Exec(dbName, "SELECT pg_create_logical_replication_slot($1, $2)", slot, plugin); err != nil {
+		return fmt.Errorf("failed to create replication slot '%s': %w", slot, err)
+	}
+	return nil
+}
+
+// CreatePublication creates the publication name in dbName for tables, which may be
+// schema qualified, or for all tables if tables is empty. Subscribers can only receive
+// the changes if the server runs with wal_level logical.
+func (pg *EmbeddedPostgres) CreatePublication(dbName, name string, tables []string) error {
+	if err := validateIdentifier("publication", name); err != nil {
+		return err
+	}
+	if err := pg.requireLogicalWAL(); err != nil {
+		return err
+	}
+	query := "CREATE PUBLICATION " + pq.QuoteIdentifier(name) + " FOR ALL TABLES"
+	if len(tables) > 0 {
+		quoted := make([]string, len(tables))
+		for i, table := range tables {
+			quoted[i] = quoteQualifiedName(table)
+		}
+		query = "CREATE PUBLICATION " + pq.QuoteIdentifier(name) + " FOR TABLE " + strings.Join(quoted, ", ")
+	}
+	if err := pg.Exec(dbName, query); err != nil {
+		return fmt.Errorf("failed to create publication '%s': %w", name, err)
+	}
+	return nil
+}
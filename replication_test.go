@@ -0,0 +1,121 @@
+package pgembed
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogicalReplication(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, WALLevel: "logical"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE TABLE orders (id int PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.CreatePublication("", "orders_pub", []string{"public.orders"}); err != nil {
+		t.Fatalf("CreatePublication() failed: %v", err)
+	}
+	if err := pg.CreateLogicalSlot("", "cdc", "test_decoding"); err != nil {
+		t.Fatalf("CreateLogicalSlot() failed: %v", err)
+	}
+	if err := pg.Exec("", "INSERT INTO orders VALUES (1)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	var changes []string
+	db, err := pg.db("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT data FROM pg_logical_slot_get_changes('cdc', NULL, NULL)")
+	if err != nil {
+		t.Fatalf("reading changes failed: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, data)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(changes, "\n"); !strings.Contains(got, "table public.orders: INSERT: id[integer]:1") {
+		t.Errorf("got changes %q, want the insert into orders", got)
+	}
+}
+
+func TestLogicalSlotRequiresLogicalWALLevel(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	err = pg.CreateLogicalSlot("", "cdc", "")
+	if err == nil || !strings.Contains(err.Error(), "WALLevel") {
+		t.Errorf("CreateLogicalSlot() error = %v, want one pointing to Config.WALLevel", err)
+	}
+}
+
+func TestNewReplica(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	primary, err := New(Config{Version: DefaultVersion, DataDir: dataDir + "/primary", RuntimeDir: dataDir + "/primary"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer primary.Stop()
+	if err := primary.Exec("", "CREATE TABLE orders (id int PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	replica, err := NewReplica(primary, Config{DataDir: dataDir + "/replica", RuntimeDir: dataDir + "/replica"})
+	if err != nil {
+		t.Fatalf("NewReplica() failed: %v", err)
+	}
+	defer replica.Stop()
+
+	var inRecovery bool
+	if err := replica.QueryRow("", "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if !inRecovery {
+		t.Error("the replica is not in recovery")
+	}
+	if err := replica.Exec("", "INSERT INTO orders VALUES (0)"); err == nil {
+		t.Error("writing to the replica succeeded")
+	}
+
+	if err := primary.Exec("", "INSERT INTO orders VALUES (1)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var count int
+		if err := replica.QueryRow("", "SELECT count(*) FROM orders").Scan(&count); err != nil {
+			t.Fatalf("QueryRow() failed: %v", err)
+		}
+		if count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the row written on the primary didn't appear on the replica")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -1,10 +1,12 @@
 use postgresql_embedded::blocking::PostgreSQL as BlockingPostgresql;
 use postgresql_embedded::Error::DatabaseInitializationError;
-use postgresql_embedded::Settings;
+use postgresql_embedded::{Settings, VersionReq};
 use std::ffi::{CStr, CString};
 use std::os::raw::c_char;
+use std::panic::{self, AssertUnwindSafe};
 use std::path::PathBuf;
 use std::ptr;
+use std::sync::atomic::{AtomicU64, Ordering};
 use std::time::Duration;
 
 /// Opaque type representing the embedded PostgreSQL instance.
@@ -19,9 +21,15 @@ pub struct pgStartResult {
 
 type PgStartResult = pgStartResult;
 
+/// Number of strings handed to the caller, and freed by it with `pg_embedded_free_string`,
+/// see `pg_embedded_test_string_counts`.
+static STRINGS_ALLOCATED: AtomicU64 = AtomicU64::new(0);
+static STRINGS_FREED: AtomicU64 = AtomicU64::new(0);
+
 /// Helper to convert Rust String to C char pointer.
 /// The caller (C/Go) is responsible for freeing this string using `pg_embedded_free_string`.
 fn string_to_c_char_ptr(s: String) -> *mut c_char {
+    STRINGS_ALLOCATED.fetch_add(1, Ordering::SeqCst);
     CString::new(s)
         .unwrap_or_else(|_| CString::new("Error: Failed to create CString").unwrap())
         .into_raw()
@@ -36,90 +44,160 @@ unsafe fn c_char_ptr_to_string(ptr: *const c_char) -> Result<String, std::str::U
     CStr::from_ptr(ptr).to_str().map(String::from)
 }
 
+/// Runs f, catching any panic so that it doesn't unwind across the FFI boundary,
+/// which would abort the Go process. The panic is returned as an error message.
+fn catch_panic<T>(f: impl FnOnce() -> T) -> Result<T, String> {
+    panic::catch_unwind(AssertUnwindSafe(f)).map_err(|payload| {
+        let message = if let Some(s) = payload.downcast_ref::<&str>() {
+            s.to_string()
+        } else if let Some(s) = payload.downcast_ref::<String>() {
+            s.clone()
+        } else {
+            "unknown panic".to_string()
+        };
+        format!("panic in the Rust layer: {}", message)
+    })
+}
+
+/// Applies the URL-encoded options passed in by the Go layer
+/// (e.g. `version=%3D16.0.0&installation_dir=%2Fopt%2Fpg`) to the settings.
+fn apply_options(settings: &mut Settings, options: &str) -> Result<(), String> {
+    for (key, value) in url::form_urlencoded::parse(options.as_bytes()) {
+        match key.as_ref() {
+            "version" => {
+                settings.version = VersionReq::parse(&value)
+                    .map_err(|e| format!("invalid version '{}': {}", value, e))?;
+            }
+            "installation_dir" => {
+                settings.installation_dir = PathBuf::from(value.as_ref());
+            }
+            "trust_installation_dir" => {
+                settings.trust_installation_dir = value == "true";
+            }
+            "username" => {
+                settings.username = value.into_owned();
+            }
+            "timeout" => {
+                let seconds = value
+                    .parse::<u64>()
+                    .map_err(|e| format!("invalid timeout '{}': {}", value, e))?;
+                settings.timeout = Some(Duration::from_secs(seconds));
+            }
+            _ => return Err(format!("unknown option '{}'", key)),
+        }
+    }
+    Ok(())
+}
+
 #[no_mangle]
 pub extern "C" fn pg_embedded_create_and_start(
     data_dir_c: *const c_char,
     _runtime_dir_c: *const c_char,
     port: u16,
     password_c: *const c_char,
+    options_c: *const c_char,
 ) -> PgStartResult {
-    let mut settings = Settings::default();
-    settings.timeout = Some(Duration::from_secs(90)); // Increased timeout for setup/start
+    match catch_panic(|| {
+        let mut settings = Settings::default();
+        settings.timeout = Some(Duration::from_secs(90)); // Increased timeout for setup/start
 
-    if !data_dir_c.is_null() {
-        match unsafe { c_char_ptr_to_string(data_dir_c) } {
-            Ok(s) if !s.is_empty() => {
-                settings.data_dir = PathBuf::from(s);
-            }
-            Err(e) => {
-                let error_str = format!("failed to convert data_dir_c to string: {}", e);
-                return PgStartResult {
-                    pg_ptr: ptr::null_mut(),
-                    error_msg: string_to_c_char_ptr(error_str),
-                };
+        if !data_dir_c.is_null() {
+            match unsafe { c_char_ptr_to_string(data_dir_c) } {
+                Ok(s) if !s.is_empty() => {
+                    settings.data_dir = PathBuf::from(s);
+                }
+                Err(e) => {
+                    let error_str = format!("failed to convert data_dir_c to string: {}", e);
+                    return PgStartResult {
+                        pg_ptr: ptr::null_mut(),
+                        error_msg: string_to_c_char_ptr(error_str),
+                    };
+                }
+                _ => {}
             }
-            _ => {}
         }
-    }
 
-    if port > 0 {
-        settings.port = port;
-    }
+        if port > 0 {
+            settings.port = port;
+        }
 
-    if !password_c.is_null() {
-        match unsafe { c_char_ptr_to_string(password_c) } {
-            Ok(s) if !s.is_empty() => {
-                settings.password = s;
+        if !password_c.is_null() {
+            match unsafe { c_char_ptr_to_string(password_c) } {
+                Ok(s) if !s.is_empty() => {
+                    settings.password = s;
+                }
+                Err(e) => {
+                    let error_str = format!("failed to convert password_c to string: {}", e);
+                    return PgStartResult {
+                        pg_ptr: ptr::null_mut(),
+                        error_msg: string_to_c_char_ptr(error_str),
+                    };
+                }
+                _ => {}
             }
-            Err(e) => {
-                let error_str = format!("failed to convert password_c to string: {}", e);
+        }
+
+        if !options_c.is_null() {
+            let result = match unsafe { c_char_ptr_to_string(options_c) } {
+                Ok(s) => apply_options(&mut settings, &s),
+                Err(e) => Err(format!("failed to convert options_c to string: {}", e)),
+            };
+            if let Err(error_str) = result {
                 return PgStartResult {
                     pg_ptr: ptr::null_mut(),
                     error_msg: string_to_c_char_ptr(error_str),
                 };
             }
-            _ => {}
         }
-    }
 
-    let mut pg = BlockingPostgresql::new(settings);
+        let mut pg = BlockingPostgresql::new(settings);
 
-    if let Err(e) = pg.setup() {
-        let error_str = match e {
-            DatabaseInitializationError(reason) => format!("setup failed: {}", reason),
-            _ => format!("setup failed: {}", e.to_string()),
-        };
-        return PgStartResult {
-            pg_ptr: ptr::null_mut(),
-            error_msg: string_to_c_char_ptr(error_str),
-        };
-    }
+        if let Err(e) = pg.setup() {
+            let error_str = match e {
+                DatabaseInitializationError(reason) => format!("setup failed: {}", reason),
+                _ => format!("setup failed: {}", e.to_string()),
+            };
+            return PgStartResult {
+                pg_ptr: ptr::null_mut(),
+                error_msg: string_to_c_char_ptr(error_str),
+            };
+        }
+
+        if let Err(e) = pg.start() {
+            let error_str = format!("start failed: {}", e.to_string());
+            return PgStartResult {
+                pg_ptr: ptr::null_mut(),
+                error_msg: string_to_c_char_ptr(error_str),
+            };
+        }
 
-    if let Err(e) = pg.start() {
-        let error_str = format!("start failed: {}", e.to_string());
-        return PgStartResult {
+        PgStartResult {
+            pg_ptr: Box::into_raw(Box::new(pg)),
+            error_msg: ptr::null_mut(),
+        }
+    }) {
+        Ok(result) => result,
+        Err(error_str) => PgStartResult {
             pg_ptr: ptr::null_mut(),
             error_msg: string_to_c_char_ptr(error_str),
-        };
-    }
-
-    PgStartResult {
-        pg_ptr: Box::into_raw(Box::new(pg)),
-        error_msg: ptr::null_mut(),
+        },
     }
 }
 
 #[no_mangle]
 pub extern "C" fn pg_embedded_stop(pg_ptr: *mut EmbeddedPg) -> bool {
-    if pg_ptr.is_null() {
-        return false;
-    }
-    // Reconstitute the Box and let it drop, which calls `pg.stop()` if not already stopped
-    // and handles cleanup via the Drop trait.
-    let pg = unsafe { Box::from_raw(pg_ptr) };
-    let result = pg.stop();
-    // pg is dropped when it goes out of scope here.
-    result.is_ok()
+    catch_panic(|| {
+        if pg_ptr.is_null() {
+            return false;
+        }
+        // Reconstitute the Box and let it drop, which calls `pg.stop()` if not already stopped
+        // and handles cleanup via the Drop trait.
+        let pg = unsafe { Box::from_raw(pg_ptr) };
+        let result = pg.stop();
+        // pg is dropped when it goes out of scope here.
+        result.is_ok()
+    })
+    .unwrap_or(false)
 }
 
 #[no_mangle]
@@ -127,35 +205,38 @@ pub extern "C" fn pg_embedded_get_connection_string(
     pg_ptr: *const EmbeddedPg,
     db_name_c: *const c_char,
 ) -> *mut c_char {
-    if pg_ptr.is_null() {
-        return std::ptr::null_mut();
-    }
-    let pg = unsafe { &*pg_ptr };
-    let db_name =
-        unsafe { c_char_ptr_to_string(db_name_c).unwrap_or_else(|_| "postgres".to_string()) };
-
-    let settings = pg.settings();
-    let user = if settings.username.is_empty() {
-        "postgres".to_string()
-    } else {
-        settings.username.clone() // Clone to get a String, or we can work with &str
-    };
-    let host = "localhost"; // postgresql-embedded runs on localhost
-    let port = settings.port;
-
-    let userinfo_part = if !settings.password.is_empty() {
-        // Note: Passwords with special characters might need URL encoding.
-        // This basic construction assumes simple passwords or that the Go driver handles it.
-        format!("{}:{}@", user, settings.password)
-    } else {
-        format!("{}@", user)
-    };
-
-    let conn_str = format!(
-        "postgresql://{}{}:{}/{}",
-        userinfo_part, host, port, db_name
-    );
-    string_to_c_char_ptr(conn_str)
+    catch_panic(|| {
+        if pg_ptr.is_null() {
+            return std::ptr::null_mut();
+        }
+        let pg = unsafe { &*pg_ptr };
+        let db_name =
+            unsafe { c_char_ptr_to_string(db_name_c).unwrap_or_else(|_| "postgres".to_string()) };
+
+        let settings = pg.settings();
+        let user = if settings.username.is_empty() {
+            "postgres".to_string()
+        } else {
+            settings.username.clone() // Clone to get a String, or we can work with &str
+        };
+        let host = "localhost"; // postgresql-embedded runs on localhost
+        let port = settings.port;
+
+        let userinfo_part = if !settings.password.is_empty() {
+            // Note: Passwords with special characters might need URL encoding.
+            // This basic construction assumes simple passwords or that the Go driver handles it.
+            format!("{}:{}@", user, settings.password)
+        } else {
+            format!("{}@", user)
+        };
+
+        let conn_str = format!(
+            "postgresql://{}{}:{}/{}",
+            userinfo_part, host, port, db_name
+        );
+        string_to_c_char_ptr(conn_str)
+    })
+    .unwrap_or(std::ptr::null_mut())
 }
 
 #[no_mangle]
@@ -163,16 +244,19 @@ pub extern "C" fn pg_embedded_create_database(
     pg_ptr: *mut EmbeddedPg,
     db_name_c: *const c_char,
 ) -> bool {
-    if pg_ptr.is_null() || db_name_c.is_null() {
-        return false;
-    }
-    let pg = unsafe { &mut *pg_ptr };
-    let db_name = match unsafe { c_char_ptr_to_string(db_name_c) } {
-        Ok(s) if !s.is_empty() => s,
-        _ => return false,
-    };
+    catch_panic(|| {
+        if pg_ptr.is_null() || db_name_c.is_null() {
+            return false;
+        }
+        let pg = unsafe { &mut *pg_ptr };
+        let db_name = match unsafe { c_char_ptr_to_string(db_name_c) } {
+            Ok(s) if !s.is_empty() => s,
+            _ => return false,
+        };
 
-    pg.create_database(&db_name).is_ok()
+        pg.create_database(&db_name).is_ok()
+    })
+    .unwrap_or(false)
 }
 
 #[no_mangle]
@@ -180,42 +264,79 @@ pub extern "C" fn pg_embedded_drop_database(
     pg_ptr: *mut EmbeddedPg,
     db_name_c: *const c_char,
 ) -> bool {
-    if pg_ptr.is_null() || db_name_c.is_null() {
-        return false;
-    }
-    let pg = unsafe { &mut *pg_ptr };
-    let db_name = match unsafe { c_char_ptr_to_string(db_name_c) } {
-        Ok(s) if !s.is_empty() => s,
-        _ => return false,
-    };
+    catch_panic(|| {
+        if pg_ptr.is_null() || db_name_c.is_null() {
+            return false;
+        }
+        let pg = unsafe { &mut *pg_ptr };
+        let db_name = match unsafe { c_char_ptr_to_string(db_name_c) } {
+            Ok(s) if !s.is_empty() => s,
+            _ => return false,
+        };
 
-    pg.drop_database(&db_name).is_ok()
+        pg.drop_database(&db_name).is_ok()
+    })
+    .unwrap_or(false)
 }
 
+/// Frees a string that was allocated by Rust and passed to C.
 #[no_mangle]
-pub extern "C" fn pg_embedded_database_exists(
-    pg_ptr: *const EmbeddedPg,
-    db_name_c: *const c_char,
-) -> bool {
-    if pg_ptr.is_null() || db_name_c.is_null() {
-        return false;
-    }
-    let pg = unsafe { &*pg_ptr };
-    let db_name = match unsafe { c_char_ptr_to_string(db_name_c) } {
-        Ok(s) if !s.is_empty() => s,
-        _ => return false,
-    };
+pub extern "C" fn pg_embedded_free_string(s: *mut c_char) {
+    let _ = catch_panic(|| {
+        if s.is_null() {
+            return;
+        }
+        unsafe {
+            let _ = CString::from_raw(s);
+        }
+        STRINGS_FREED.fetch_add(1, Ordering::SeqCst);
+    });
+}
 
-    pg.database_exists(&db_name).unwrap_or(false)
+/// Test-only: panics inside `catch_panic`, like a failing entry point would, and returns
+/// the panic message, to be freed with `pg_embedded_free_string`. The Go tests use it to
+/// check that a panic doesn't abort the process.
+#[no_mangle]
+pub extern "C" fn pg_embedded_test_panic() -> *mut c_char {
+    match catch_panic::<()>(|| panic!("test panic")) {
+        Ok(()) => ptr::null_mut(),
+        Err(error_str) => string_to_c_char_ptr(error_str),
+    }
 }
 
-/// Frees a string that was allocated by Rust and passed to C.
+/// Test-only: reports how many strings were handed to the caller and how many were freed
+/// with `pg_embedded_free_string`. The Go tests use it to check that none leaks.
 #[no_mangle]
-pub extern "C" fn pg_embedded_free_string(s: *mut c_char) {
-    if s.is_null() {
-        return;
+pub extern "C" fn pg_embedded_test_string_counts(allocated: *mut u64, freed: *mut u64) {
+    if !allocated.is_null() {
+        unsafe { *allocated = STRINGS_ALLOCATED.load(Ordering::SeqCst) };
+    }
+    if !freed.is_null() {
+        unsafe { *freed = STRINGS_FREED.load(Ordering::SeqCst) };
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_panic_returns_the_panic_message() {
+        let message = pg_embedded_test_panic();
+        let result = unsafe { CStr::from_ptr(message) }
+            .to_str()
+            .map(String::from);
+        pg_embedded_free_string(message);
+        assert_eq!(
+            result,
+            Ok("panic in the Rust layer: test panic".to_string())
+        );
     }
-    unsafe {
-        let _ = CString::from_raw(s);
+
+    #[test]
+    fn catch_panic_returns_the_panic_message() {
+        let result: Result<bool, String> = catch_panic(|| panic!("boom"));
+        assert_eq!(result, Err("panic in the Rust layer: boom".to_string()));
+        assert_eq!(catch_panic(|| true), Ok(true));
     }
 }
@@ -0,0 +1,135 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lib/pq"
+)
+
+// ListTables returns the sorted names of the tables in schema, "public" if empty, of
+// the database dbName.
+func (pg *EmbeddedPostgres) ListTables(dbName, schema string) ([]string, error) {
+	if schema == "" {
+		schema = "public"
+	}
+	db, err := pg.db(dbName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = $1 AND table_type = 'BASE TABLE' ORDER BY table_name`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+	}
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+	}
+	return tables, nil
+}
+
+// TableExists reports whether table exists in schema, "public" if empty, of the
+// database dbName.
+func (pg *EmbeddedPostgres) TableExists(dbName, schema, table string) (bool, error) {
+	if schema == "" {
+		schema = "public"
+	}
+	var exists bool
+	err := pg.QueryRow(dbName, `SELECT EXISTS (SELECT 1 FROM information_schema.tables
+		WHERE table_schema = $1 AND table_name = $2 AND table_type = 'BASE TABLE')`, schema, table).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check table '%s.%s' in database '%s': %w", schema, table, dbName, err)
+	}
+	return exists, nil
+}
+
+// CreateSchema creates schema in the database dbName.
+func (pg *EmbeddedPostgres) CreateSchema(dbName, schema string) error {
+	if err := validateIdentifier("schema name", schema); err != nil {
+		return err
+	}
+	if err := pg.Exec(dbName, "CREATE SCHEMA "+pq.QuoteIdentifier(schema)); err != nil {
+		return fmt.Errorf("failed to create schema '%s': %w", schema, err)
+	}
+	return nil
+}
+
+// DropSchema drops schema from the database dbName. With cascade, the objects it
+// contains are dropped too, otherwise it must be empty.
+func (pg *EmbeddedPostgres) DropSchema(dbName, schema string, cascade bool) error {
+	if err := validateIdentifier("schema name", schema); err != nil {
+		return err
+	}
+	query := "DROP SCHEMA " + pq.QuoteIdentifier(schema)
+	if cascade {
+		query += " CASCADE"
+	}
+	if err := pg.Exec(dbName, query); err != nil {
+		return fmt.Errorf("failed to drop schema '%s': %w", schema, err)
+	}
+	return nil
+}
+
+// CreateTablespace creates the tablespace name in the directory location, which is
+// created if needed and must otherwise be empty. Tables are placed in it with e.g.
+// "CREATE TABLE ... TABLESPACE name". Pick a location outside of the data directory,
+// a temporary data directory is removed by Stop but other locations are not.
+func (pg *EmbeddedPostgres) CreateTablespace(name, location string) error {
+	if err := validateIdentifier("tablespace name", name); err != nil {
+		return err
+	}
+	absLocation, err := filepath.Abs(location)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for tablespace location: %w", err)
+	}
+	// The server runs as the current user, so it owns the directory; like the data
+	// directory, it must not be accessible to others.
+	if err := os.MkdirAll(absLocation, 0700); err != nil {
+		return fmt.Errorf("failed to create tablespace location %s: %w", absLocation, err)
+	}
+	if err := pg.Exec("", "CREATE TABLESPACE "+pq.QuoteIdentifier(name)+" LOCATION "+pq.QuoteLiteral(absLocation)); err != nil {
+		return fmt.Errorf("failed to create tablespace '%s': %w", name, err)
+	}
+	return nil
+}
+
+// DropTablespace drops the tablespace name, which must be empty. Its directory is left
+// in place.
+func (pg *EmbeddedPostgres) DropTablespace(name string) error {
+	if err := validateIdentifier("tablespace name", name); err != nil {
+		return err
+	}
+	if err := pg.Exec("", "DROP TABLESPACE "+pq.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop tablespace '%s': %w", name, err)
+	}
+	return nil
+}
+
+// RowCount returns the number of rows of table, which may be schema qualified, in the
+// database dbName.
+func (pg *EmbeddedPostgres) RowCount(dbName, table string) (int64, error) {
+	if table == "" {
+		return 0, errors.New("table name cannot be empty")
+	}
+	var count int64
+	err := pg.QueryRow(dbName, "SELECT count(*) FROM "+quoteQualifiedName(table)).Scan(&count)
+	if sqlState(err) == "42P01" { // undefined_table
+		return 0, fmt.Errorf("table '%s' does not exist in database '%s'", table, dbName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the rows of table '%s': %w", table, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,161 @@
+package pgembed
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListTables(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	tables, err := pg.ListTables("", "")
+	if err != nil {
+		t.Fatalf("ListTables() failed: %v", err)
+	}
+	if len(tables) != 0 {
+		t.Errorf("ListTables() = %v for a new database, want none", tables)
+	}
+
+	setup := `CREATE TABLE users (id int); CREATE TABLE accounts (id int); CREATE VIEW active AS SELECT 1;
+		CREATE SCHEMA audit; CREATE TABLE audit.events (id int)`
+	if err := pg.Exec("", setup); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	tables, err = pg.ListTables("", "")
+	if err != nil {
+		t.Fatalf("ListTables() failed: %v", err)
+	}
+	if want := []string{"accounts", "users"}; !reflect.DeepEqual(tables, want) {
+		t.Errorf("ListTables() = %v, want %v", tables, want)
+	}
+
+	for _, tt := range []struct {
+		schema, table string
+		want          bool
+	}{
+		{"", "users", true},
+		{"public", "events", false},
+		{"audit", "events", true},
+		{"", "active", false},
+	} {
+		exists, err := pg.TableExists("", tt.schema, tt.table)
+		if err != nil {
+			t.Fatalf("TableExists() failed: %v", err)
+		}
+		if exists != tt.want {
+			t.Errorf("TableExists(%q, %q) = %v, want %v", tt.schema, tt.table, exists, tt.want)
+		}
+	}
+}
+
+func TestCreateAndDropSchema(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateSchema("", "tenant_a"); err != nil {
+		t.Fatalf("CreateSchema() failed: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE tenant_a.orders (id int)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	if err := pg.DropSchema("", "tenant_a", false); err == nil {
+		t.Error("DropSchema() of a non-empty schema without cascade succeeded")
+	}
+	if err := pg.DropSchema("", "tenant_a", true); err != nil {
+		t.Fatalf("DropSchema() with cascade failed: %v", err)
+	}
+	exists, err := pg.TableExists("", "tenant_a", "orders")
+	if err != nil {
+		t.Fatalf("TableExists() failed: %v", err)
+	}
+	if exists {
+		t.Error("table still exists after dropping its schema with cascade")
+	}
+
+	if err := pg.CreateSchema("", "bad; DROP SCHEMA public"); err == nil {
+		t.Error("CreateSchema() with an invalid name succeeded")
+	}
+}
+
+func TestCreateAndDropTablespace(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+	location := dataDir + "-tablespace"
+	defer os.RemoveAll(location)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateTablespace("fast", location); err != nil {
+		t.Fatalf("CreateTablespace() failed: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE hot (id int) TABLESPACE fast"); err != nil {
+		t.Fatalf("creating a table in the tablespace failed: %v", err)
+	}
+	var tablespace string
+	if err := pg.QueryRow("", "SELECT tablespace FROM pg_tables WHERE tablename = 'hot'").Scan(&tablespace); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if tablespace != "fast" {
+		t.Errorf("table is in tablespace %q, want fast", tablespace)
+	}
+
+	if err := pg.DropTablespace("fast"); err == nil {
+		t.Error("DropTablespace() of a non-empty tablespace succeeded")
+	}
+	if err := pg.Exec("", "DROP TABLE hot"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.DropTablespace("fast"); err != nil {
+		t.Errorf("DropTablespace() failed: %v", err)
+	}
+}
+
+func TestRowCount(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	setup := `CREATE TABLE "Fruits" (name text); INSERT INTO "Fruits" VALUES ('apple'), ('kiwi');
+		CREATE SCHEMA audit; CREATE TABLE audit.events (id int)`
+	if err := pg.Exec("", setup); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	for table, want := range map[string]int64{"Fruits": 2, "audit.events": 0} {
+		count, err := pg.RowCount("", table)
+		if err != nil {
+			t.Fatalf("RowCount(%q) failed: %v", table, err)
+		}
+		if count != want {
+			t.Errorf("RowCount(%q) = %d, want %d", table, count, want)
+		}
+	}
+
+	if _, err := pg.RowCount("", "missing"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("RowCount() of a missing table = %v, want a does not exist error", err)
+	}
+}
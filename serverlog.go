@@ -0,0 +1,89 @@
+package pgembed
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// serverLogFile is the file, in the log directory of the data directory, the server
+// logs to when Config.LogWriter is set.
+const serverLogFile = "pgembed.log"
+
+// serverLogPath returns the path of the serverLogFile of dataDir.
+func serverLogPath(dataDir string) string {
+	return filepath.Join(dataDir, "log", serverLogFile)
+}
+
+// logSettings returns the postgresql.conf settings making the server log to the
+// serverLogFile, with the logging collector, without ever rotating it.
+func logSettings() map[string]string {
+	return map[string]string{
+		"logging_collector": "on",
+		"log_directory":     "log",
+		"log_filename":      serverLogFile,
+		"log_rotation_age":  "0",
+		"log_rotation_size": "0",
+	}
+}
+
+// logTailer copies the lines appended to a log file to a writer, see tailLog.
+type logTailer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// tailLog copies the lines appended to the file at path after offset to w, one line per
+// Write, until close is called. The file doesn't need to exist yet.
+func tailLog(path string, offset int64, w io.Writer) *logTailer {
+	t := &logTailer{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		var pending []byte // an incomplete last line
+		copyLines := func() {
+			f, err := os.Open(path)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return
+			}
+			data, _ := io.ReadAll(f)
+			offset += int64(len(data))
+			pending = append(pending, data...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				w.Write(pending[:i+1])
+				pending = pending[i+1:]
+			}
+		}
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				copyLines()
+			case <-t.stop:
+				copyLines()
+				if len(pending) > 0 {
+					w.Write(append(pending, '\n'))
+				}
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// close copies the remaining lines and stops tailing.
+func (t *logTailer) close() {
+	close(t.stop)
+	<-t.done
+}
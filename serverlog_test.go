@@ -0,0 +1,75 @@
+package pgembed
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTailLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := os.WriteFile(path, []byte("old line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(lineWriter, 10)
+	tailer := tailLog(path, int64(len("old line\n")), lines)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("first\nsec"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if line != "first\n" {
+			t.Errorf("tailed line = %q, want first", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the appended line was not tailed")
+	}
+
+	if _, err := f.WriteString("ond"); err != nil {
+		t.Fatal(err)
+	}
+	tailer.close()
+	close(lines)
+	var rest []string
+	for line := range lines {
+		rest = append(rest, line)
+	}
+	if got := strings.Join(rest, ""); got != "second\n" {
+		t.Errorf("lines tailed when closing = %q, want the incomplete last line", got)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	lines := make(lineWriter, 1000)
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, LogWriter: lines})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "SELECT 1/0"); err == nil {
+		t.Fatal("Exec() of a division by zero succeeded")
+	}
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.Contains(line, "division by zero") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the error was not written to LogWriter")
+		}
+	}
+}
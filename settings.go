@@ -0,0 +1,186 @@
+package pgembed
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// settingName matches the names of server settings, including the dotted names of
+// settings defined by extensions such as "pg_stat_statements.max".
+var settingName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validateSettingName checks that name is a valid setting name, so that it can't
+// inject into the SQL it is used in.
+func validateSettingName(name string) error {
+	if !settingName.MatchString(name) {
+		return fmt.Errorf("invalid setting name %q", name)
+	}
+	return nil
+}
+
+// reloadTimeout bounds how long SetSetting and ReloadConfig wait for the server to
+// apply the reloaded configuration.
+const reloadTimeout = 10 * time.Second
+
+// SetSetting changes a server setting with ALTER SYSTEM and reloads the configuration
+// so that it takes effect without a restart, e.g.
+// SetSetting("log_min_duration_statement", "0"). It returns once the server applied the
+// new value, which the following calls see. Settings that can only be set at server
+// start are saved but only take effect after a restart.
+//
+// ALTER SYSTEM persists the setting in postgresql.auto.conf in the data directory, which
+// takes precedence over the settings derived from Config: with a persistent DataDir, the
+// setting remains in effect after the server is restarted, until it is reset with
+// ALTER SYSTEM RESET, e.g. pg.Exec("", "ALTER SYSTEM RESET work_mem") and ReloadConfig.
+func (pg *EmbeddedPostgres) SetSetting(name, value string) error {
+	if err := validateSettingName(name); err != nil {
+		return err
+	}
+	if err := pg.Exec("", "ALTER SYSTEM SET "+name+" = "+pq.QuoteLiteral(value)); err != nil {
+		return err
+	}
+	return pg.reload()
+}
+
+// ShowSetting returns the current value of a server setting, as SHOW would.
+func (pg *EmbeddedPostgres) ShowSetting(name string) (string, error) {
+	if err := validateSettingName(name); err != nil {
+		return "", err
+	}
+	var value string
+	if err := pg.QueryRow("", "SELECT current_setting($1)", name).Scan(&value); err != nil {
+		return "", fmt.Errorf("failed to show setting '%s': %w", name, err)
+	}
+	return value, nil
+}
+
+// MaxConnections returns max_connections, the maximum number of concurrent connections
+// to the server, see Config.MaxConnections.
+func (pg *EmbeddedPostgres) MaxConnections() (int, error) {
+	value, err := pg.ShowSetting("max_connections")
+	if err != nil {
+		return 0, err
+	}
+	maxConns, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_connections %q: %w", value, err)
+	}
+	return maxConns, nil
+}
+
+// ReloadConfig makes the server reread its configuration files, e.g. after they were
+// edited, like SIGHUP would, and waits until it applied them. It fails if a
+// configuration file has errors, in which case the server keeps its current settings.
+//
+// Settings that can only be set at server start, those whose context is "postmaster"
+// in pg_settings, such as shared_buffers, port or shared_preload_libraries, require a
+// restart instead; their new values are only reported as pending_restart.
+func (pg *EmbeddedPostgres) ReloadConfig() error {
+	db, err := pg.db("")
+	if err != nil {
+		return err
+	}
+	// pg_file_settings reads the files as a reload would, reporting invalid lines.
+	var errs []string
+	result, err := db.Query("SELECT sourcefile, sourceline, error FROM pg_file_settings WHERE error IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("failed to check the configuration files: %w", err)
+	}
+	defer result.Close()
+	for result.Next() {
+		var file sql.NullString
+		var line sql.NullInt64
+		var message string
+		if err := result.Scan(&file, &line, &message); err != nil {
+			return fmt.Errorf("failed to check the configuration files: %w", err)
+		}
+		errs = append(errs, fmt.Sprintf("%s:%d: %s", file.String, line.Int64, message))
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to check the configuration files: %w", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return pg.reload()
+}
+
+// reload signals the server to reload its configuration and waits until it did, when
+// pg_conf_load_time moves forward: the reload is asynchronous, a statement right after
+// pg_reload_conf() could still see the previous values.
+func (pg *EmbeddedPostgres) reload() error {
+	db, err := pg.db("")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// pg_conf_load_time is per session, the one that will see the new values.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reload the configuration: %w", err)
+	}
+	defer conn.Close()
+
+	var loaded time.Time
+	if err := conn.QueryRowContext(ctx, "SELECT pg_conf_load_time()").Scan(&loaded); err != nil {
+		return fmt.Errorf("failed to reload the configuration: %w", err)
+	}
+	var reloaded bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_reload_conf()").Scan(&reloaded); err != nil {
+		return fmt.Errorf("failed to reload the configuration: %w", err)
+	}
+	if !reloaded {
+		return errors.New("failed to reload the configuration: the server could not be signaled")
+	}
+	deadline := time.Now().Add(reloadTimeout)
+	for {
+		var applied bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_conf_load_time() > $1", loaded).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to reload the configuration: %w", err)
+		}
+		if applied {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to reload the configuration: not applied within %s", reloadTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// CurrentSettings returns the server settings that don't have their default value,
+// by name, e.g. those set by the package, postgresql.conf or SetSetting. Maps aren't
+// ordered: sort the names for deterministic logging.
+func (pg *EmbeddedPostgres) CurrentSettings() (map[string]string, error) {
+	db, err := pg.db("")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT name, setting FROM pg_settings WHERE source != 'default'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var name, setting string
+		if err := rows.Scan(&name, &setting); err != nil {
+			return nil, fmt.Errorf("failed to query settings: %w", err)
+		}
+		settings[name] = setting
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query settings: %w", err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,141 @@
+package pgembed
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetSetting(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.SetSetting("log_statement", "all"); err != nil {
+		t.Fatalf("SetSetting() failed: %v", err)
+	}
+	// Applied when SetSetting returns, without waiting.
+	if value, err := pg.ShowSetting("log_statement"); err != nil || value != "all" {
+		t.Errorf("ShowSetting() after SetSetting() = %q, %v, want \"all\"", value, err)
+	}
+
+	if err := pg.SetSetting("log_statement = 'none'; DROP TABLE x; --", "all"); err == nil {
+		t.Error("SetSetting() with an invalid name succeeded")
+	}
+	if _, err := pg.ShowSetting("no_such_setting"); err == nil {
+		t.Error("ShowSetting() of an unknown setting succeeded")
+	}
+}
+
+func TestValidateSettingName(t *testing.T) {
+	for _, name := range []string{"work_mem", "pg_stat_statements.max", "DateStyle"} {
+		if err := validateSettingName(name); err != nil {
+			t.Errorf("validateSettingName(%q) failed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "work mem", "a.b.c", "x;y", "1st"} {
+		if err := validateSettingName(name); err == nil {
+			t.Errorf("validateSettingName(%q) succeeded", name)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	autoConf := filepath.Join(dataDir, "postgresql.auto.conf")
+	if err := os.WriteFile(autoConf, []byte("work_mem = '12MB'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig() failed: %v", err)
+	}
+	if value, err := pg.ShowSetting("work_mem"); err != nil || value != "12MB" {
+		t.Errorf("ShowSetting() after ReloadConfig() = %q, %v, want \"12MB\"", value, err)
+	}
+
+	if err := os.WriteFile(autoConf, []byte("work_mem = 'lots'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.ReloadConfig(); err == nil {
+		t.Error("ReloadConfig() with an invalid setting succeeded")
+	}
+}
+
+func TestCurrentSettings(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:    DefaultVersion,
+		DataDir:    dataDir,
+		RuntimeDir: dataDir,
+		AuthMethod: "scram-sha-256",
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	settings, err := pg.CurrentSettings()
+	if err != nil {
+		t.Fatalf("CurrentSettings() failed: %v", err)
+	}
+	if settings["password_encoding"] != "scram-sha-256" {
+		t.Errorf("CurrentSettings()[password_encoding] = %q, want scram-sha-256", settings["password_encoding"])
+	}
+	if _, ok := settings["geqo"]; ok {
+		t.Error("CurrentSettings() contains geqo, which has its default value")
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, MaxConnections: 10})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if got, err := pg.MaxConnections(); err != nil || got != 10 {
+		t.Errorf("MaxConnections() = %d, %v, want 10", got, err)
+	}
+
+	warnings := make(lineWriter, 10)
+	warningOutput = warnings
+	defer func() { warningOutput = os.Stderr }()
+
+	for maxOpenConns, want := range map[int]int{0: 10, 5: 5, 50: 10} {
+		db, err := pg.OpenDB("", maxOpenConns)
+		if err != nil {
+			t.Fatalf("OpenDB() failed: %v", err)
+		}
+		if got := db.Stats().MaxOpenConnections; got != want {
+			t.Errorf("OpenDB(%d) has a maximum of %d open connections, want %d", maxOpenConns, got, want)
+		}
+		db.Close()
+	}
+	select {
+	case warning := <-warnings:
+		if !strings.Contains(warning, "capping the pool") {
+			t.Errorf("unexpected warning: %s", warning)
+		}
+	default:
+		t.Error("no warning when capping the pool")
+	}
+}
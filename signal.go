@@ -0,0 +1,47 @@
+package pgembed
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// InstallSignalHandler stops the instance when the process receives one of the signals,
+// os.Interrupt and SIGTERM if none are given, e.g. so that a Ctrl-C of a command line
+// tool doesn't leave the server and its temporary directories behind. The handler is
+// then uninstalled and the signal sent again, so that the process terminates as it would
+// have without it. Programs that handle the signals themselves should call Stop from
+// their handler instead.
+//
+// The returned function uninstalls the handler. Until then, the instance can't be
+// garbage collected. It is safe to call Stop before the signal arrives.
+func (pg *EmbeddedPostgres) InstallSignalHandler(sig ...os.Signal) (cancel func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+
+	done := make(chan struct{})
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+	go func() {
+		select {
+		case s := <-signals:
+			if err := pg.Stop(); err != nil {
+				fmt.Fprintf(warningOutput, "pgembed: WARNING: failed to stop the instance on %v: %v\n", s, err)
+			}
+			cancel()
+			raiseSignal(s)
+		case <-done:
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,75 @@
+package pgembed
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInstallSignalHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can't be sent on Windows")
+	}
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	// Without a handler of the test, SIGHUP, sent again by the handler, would terminate it.
+	received := make(chan os.Signal, 2)
+	signal.Notify(received, syscall.SIGHUP)
+	defer signal.Stop(received)
+
+	cancel := pg.InstallSignalHandler(syscall.SIGHUP)
+	defer cancel()
+	raiseSignal(syscall.SIGHUP)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("received the signal %d times, want it sent again by the handler", i)
+		}
+	}
+	if pg.IsRunning() {
+		t.Error("instance is running after the signal")
+	}
+}
+
+func TestCancelSignalHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can't be sent on Windows")
+	}
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, syscall.SIGHUP)
+	defer signal.Stop(received)
+
+	cancel := pg.InstallSignalHandler(syscall.SIGHUP)
+	cancel()
+	cancel()
+	raiseSignal(syscall.SIGHUP)
+	select {
+	case <-received:
+	case <-time.After(30 * time.Second):
+		t.Fatal("the signal was not received")
+	}
+	if !pg.IsRunning() {
+		t.Error("instance was stopped by a cancelled signal handler")
+	}
+}
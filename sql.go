@@ -0,0 +1,539 @@
+package pgembed
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// ErrExtensionNotAvailable is returned by CreateExtension when the extension is not
+// part of the PostgreSQL binaries, e.g. PostGIS.
+var ErrExtensionNotAvailable = errors.New("extension not available")
+
+// openDB opens a connection pool to dbName as the superuser, with the driver of
+// Config.DriverName. The caller is responsible for closing it.
+func (pg *EmbeddedPostgres) openDB(dbName string) (*sql.DB, error) {
+	dsn, err := pg.ConnectionString(dbName)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(pg.config.driverName(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database '%s': %w", dbName, err)
+	}
+	return db, nil
+}
+
+// OpenDB opens a pool of connections to dbName as the superuser, with at most
+// maxOpenConns open connections, or as many as the server allows if 0. A larger
+// maxOpenConns is capped to the server's MaxConnections, with a warning, rather than
+// failing with "too many clients" errors. Other connections, including those of the
+// helper methods, count against the limit too. The caller is responsible for closing
+// the pool.
+func (pg *EmbeddedPostgres) OpenDB(dbName string, maxOpenConns int) (*sql.DB, error) {
+	maxConns, err := pg.MaxConnections()
+	if err != nil {
+		return nil, err
+	}
+	if maxOpenConns > maxConns {
+		fmt.Fprintf(warningOutput, "pgembed: WARNING: capping the pool to database '%s' to %d connections, the server's max_connections, instead of %d\n", dbName, maxConns, maxOpenConns)
+	}
+	if maxOpenConns <= 0 || maxOpenConns > maxConns {
+		maxOpenConns = maxConns
+	}
+	db, err := pg.openDB(dbName)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	return db, nil
+}
+
+// db returns the pool of connections to dbName, opening it the first time. The
+// pools are closed by Stop.
+func (pg *EmbeddedPostgres) db(dbName string) (*sql.DB, error) {
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	pg.poolsMu.Lock()
+	defer pg.poolsMu.Unlock()
+
+	if db, ok := pg.pools[dbName]; ok {
+		return db, nil
+	}
+	db, err := pg.openDB(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if pg.pools == nil {
+		pg.pools = map[string]*sql.DB{}
+	}
+	pg.pools[dbName] = db
+	return db, nil
+}
+
+// closeDB closes the pool of connections to dbName, if it is open.
+func (pg *EmbeddedPostgres) closeDB(dbName string) {
+	pg.poolsMu.Lock()
+	defer pg.poolsMu.Unlock()
+
+	if db, ok := pg.pools[dbName]; ok {
+		db.Close()
+		delete(pg.pools, dbName)
+	}
+}
+
+// closeDBs closes all the pools of connections.
+func (pg *EmbeddedPostgres) closeDBs() {
+	pg.poolsMu.Lock()
+	defer pg.poolsMu.Unlock()
+
+	for dbName, db := range pg.pools {
+		db.Close()
+		delete(pg.pools, dbName)
+	}
+}
+
+// Exec executes a statement in the database dbName, e.g. to set up test data. The
+// connections are pooled and reused across calls.
+func (pg *EmbeddedPostgres) Exec(dbName, query string, args ...any) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to execute query in database '%s': %w", dbName, err)
+	}
+	return nil
+}
+
+// ExecBatch executes a script of statements separated by semicolons in the database
+// dbName, e.g. a schema file. It is sent as a single simple query, so that the server
+// splits the statements, including dollar-quoted function bodies, and runs them in one
+// implicit transaction unless the script has its own BEGIN and COMMIT statements. The
+// connection is closed afterwards, so that settings or an unfinished transaction of
+// the script don't leak into other calls.
+func (pg *EmbeddedPostgres) ExecBatch(dbName, script string) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database '%s': %w", dbName, err)
+	}
+	defer conn.Close()
+	defer conn.Raw(func(any) error { return driver.ErrBadConn })
+
+	// Without arguments, lib/pq and pgx use the simple query protocol, which allows
+	// several statements.
+	if _, err := conn.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("failed to execute batch in database '%s': %w", dbName, err)
+	}
+	return nil
+}
+
+// QueryRow executes a query in the database dbName that is expected to return at most
+// one row. Errors are deferred until the row's Scan method is called.
+func (pg *EmbeddedPostgres) QueryRow(dbName, query string, args ...any) *sql.Row {
+	db, err := pg.db(dbName)
+	if err != nil {
+		// sql.Row can't be created with an error, let a pool that always fails to
+		// connect produce one.
+		db := sql.OpenDB(errConnector{err})
+		defer db.Close()
+		return db.QueryRow(query, args...)
+	}
+	return db.QueryRow(query, args...)
+}
+
+// WithRollback runs fn in a transaction in dbName that is always rolled back, also
+// when fn succeeds, so that nothing it does persists. It returns the error of fn, if
+// any. Code that depends on committed data being visible to other connections can't be
+// tested this way.
+func (pg *EmbeddedPostgres) WithRollback(dbName string, fn func(tx *sql.Tx) error) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction in database '%s': %w", dbName, err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("failed to roll back transaction in database '%s': %w", dbName, err)
+	}
+	return nil
+}
+
+// TryAdvisoryLock tries to acquire the session-level advisory lock key in dbName with
+// pg_try_advisory_lock, without waiting. It reports whether the lock was obtained and
+// returns a function releasing it, which does nothing if it wasn't. The lock is held
+// by a connection reserved until it is released.
+func (pg *EmbeddedPostgres) TryAdvisoryLock(dbName string, key int64) (bool, func() error, error) {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return false, nil, err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to connect to database '%s': %w", dbName, err)
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Close()
+		return false, nil, fmt.Errorf("failed to lock advisory lock %d: %w", key, err)
+	}
+	if !locked {
+		conn.Close()
+		return false, func() error { return nil }, nil
+	}
+
+	var once sync.Once
+	var unlockErr error
+	unlock := func() error {
+		once.Do(func() {
+			// Session-level locks are released by the session that holds them.
+			var unlocked bool
+			err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", key).Scan(&unlocked)
+			if err == nil && !unlocked {
+				err = errors.New("the lock was not held")
+			}
+			if err != nil {
+				unlockErr = fmt.Errorf("failed to unlock advisory lock %d: %w", key, err)
+				// Discard the connection rather than returning it to the pool, closing
+				// the session releases the lock.
+				conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+			conn.Close()
+		})
+		return unlockErr
+	}
+	return true, unlock, nil
+}
+
+// maxIdentifierLength is the maximum length of PostgreSQL identifiers in bytes
+// (NAMEDATALEN - 1); longer ones are silently truncated by the server.
+const maxIdentifierLength = 63
+
+// identifierPattern matches the identifiers accepted for database and role names.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// validateIdentifier checks that name, described by kind in errors, is a plain
+// PostgreSQL identifier, so that it can't break or inject into the SQL it is used in.
+func validateIdentifier(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s cannot be empty", kind)
+	}
+	if len(name) > maxIdentifierLength {
+		return fmt.Errorf("%s %q is longer than %d bytes", kind, name, maxIdentifierLength)
+	}
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("%s %q is not a valid identifier: it must start with a letter or underscore, followed by letters, digits, underscores or dollar signs", kind, name)
+	}
+	return nil
+}
+
+// errConnector is a driver.Connector failing with err.
+type errConnector struct {
+	err error
+}
+
+func (c errConnector) Connect(context.Context) (driver.Conn, error) { return nil, c.err }
+func (c errConnector) Driver() driver.Driver                        { return errDriver(c) }
+
+// errDriver is a driver.Driver failing with err.
+type errDriver struct {
+	err error
+}
+
+func (d errDriver) Open(string) (driver.Conn, error) { return nil, d.err }
+
+// createDatabases creates the databases that don't exist yet, see Config.Databases.
+func (pg *EmbeddedPostgres) createDatabases(specs []DatabaseSpec) error {
+	var errs []error
+	for _, spec := range specs {
+		if err := pg.createDatabase(spec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createDatabase creates the database described by spec, unless it exists.
+func (pg *EmbeddedPostgres) createDatabase(spec DatabaseSpec) error {
+	if err := validateIdentifier("database name", spec.Name); err != nil {
+		return err
+	}
+	exists, err := pg.DatabaseExists(spec.Name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	db, err := pg.db("")
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(spec.sql()); err != nil {
+		return fmt.Errorf("failed to create database '%s': %w", spec.Name, err)
+	}
+	return nil
+}
+
+// sql returns the CREATE DATABASE statement of the spec.
+func (spec DatabaseSpec) sql() string {
+	query := "CREATE DATABASE " + pq.QuoteIdentifier(spec.Name)
+	if spec.Owner != "" {
+		query += " OWNER " + pq.QuoteIdentifier(spec.Owner)
+	}
+	if spec.Encoding != "" {
+		query += " ENCODING " + pq.QuoteLiteral(spec.Encoding)
+	}
+	if spec.Template != "" {
+		query += " TEMPLATE " + pq.QuoteIdentifier(spec.Template)
+	}
+	return query
+}
+
+// DatabasesError is returned by CreateDatabases and DropDatabases when some of the
+// databases could not be created or dropped, telling which ones to clean up.
+type DatabasesError struct {
+	// Op is "create" or "drop".
+	Op string
+	// Succeeded are the databases that were created or dropped.
+	Succeeded []string
+	// Failed are the errors of the other databases, by name.
+	Failed map[string]error
+}
+
+func (e *DatabasesError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failures := make([]string, len(names))
+	for i, name := range names {
+		failures[i] = fmt.Sprintf("'%s': %v", name, e.Failed[name])
+	}
+	return fmt.Sprintf("failed to %s databases %s; succeeded: %v", e.Op, strings.Join(failures, "; "), e.Succeeded)
+}
+
+// Unwrap returns the errors of the failed databases.
+func (e *DatabasesError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// CreateDatabases creates the databases described by specs, one after the other over a
+// single connection, which is faster than calling CreateDatabase for each. Unlike
+// Config.Databases, it fails for databases that already exist. If some databases can't
+// be created, the others still are and a *DatabasesError is returned.
+func (pg *EmbeddedPostgres) CreateDatabases(specs []DatabaseSpec) error {
+	queries := make([][2]string, len(specs)) // name and statement
+	for i, spec := range specs {
+		if err := validateIdentifier("database name", spec.Name); err != nil {
+			return err
+		}
+		queries[i] = [2]string{spec.Name, spec.sql()}
+	}
+	return pg.execEach("create", queries)
+}
+
+// DropDatabases drops the databases names, one after the other over a single
+// connection. If some databases can't be dropped, the others still are and a
+// *DatabasesError is returned.
+func (pg *EmbeddedPostgres) DropDatabases(names []string) error {
+	queries := make([][2]string, len(names)) // name and statement
+	for i, name := range names {
+		if err := validateIdentifier("database name", name); err != nil {
+			return err
+		}
+		queries[i] = [2]string{name, "DROP DATABASE " + pq.QuoteIdentifier(name)}
+	}
+	for _, name := range names {
+		// Open connections would prevent the database from being dropped.
+		pg.closeDB(name)
+	}
+	return pg.execEach("drop", queries)
+}
+
+// execEach executes the statement of each database, by name, over a single connection
+// to the maintenance database, returning a *DatabasesError for op if some fail.
+func (pg *EmbeddedPostgres) execEach(op string, queries [][2]string) error {
+	if pg.instance == nil {
+		return errors.New("instance is not running or has been stopped")
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	db, err := pg.db("")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database 'postgres': %w", err)
+	}
+	defer conn.Close()
+
+	result := &DatabasesError{Op: op, Failed: map[string]error{}}
+	for _, query := range queries {
+		if _, err := conn.ExecContext(ctx, query[1]); err != nil {
+			result.Failed[query[0]] = err
+		} else {
+			result.Succeeded = append(result.Succeeded, query[0])
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result
+	}
+	return nil
+}
+
+// ServerVersion returns the version reported by the running server, e.g. "16.4".
+func (pg *EmbeddedPostgres) ServerVersion() (string, error) {
+	db, err := pg.db("")
+	if err != nil {
+		return "", err
+	}
+
+	var version string
+	if err := db.QueryRow("SHOW server_version").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+	return version, nil
+}
+
+// ServerVersionNum returns the version of the running server as a number, e.g. 160004
+// for 16.4, which is convenient for gating version specific features:
+//
+//	if num, _ := pg.ServerVersionNum(); num >= 130000 {
+//		// DROP DATABASE ... WITH (FORCE) is available.
+//	}
+func (pg *EmbeddedPostgres) ServerVersionNum() (int, error) {
+	db, err := pg.db("")
+	if err != nil {
+		return 0, err
+	}
+
+	var version string
+	if err := db.QueryRow("SHOW server_version_num").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to query server version: %w", err)
+	}
+	num, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected server_version_num %q: %w", version, err)
+	}
+	return num, nil
+}
+
+// CreateExtension enables extension in the database dbName, if it isn't already.
+// It returns ErrExtensionNotAvailable if the extension isn't bundled with the binaries.
+func (pg *EmbeddedPostgres) CreateExtension(dbName, extension string) error {
+	if extension == "" {
+		return errors.New("extension name cannot be empty")
+	}
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+
+	var available bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = $1)", extension).Scan(&available)
+	if err != nil {
+		return fmt.Errorf("failed to check availability of extension '%s': %w", extension, err)
+	}
+	if !available {
+		return fmt.Errorf("%w: '%s' is not bundled with the PostgreSQL binaries", ErrExtensionNotAvailable, extension)
+	}
+
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS " + pq.QuoteIdentifier(extension)); err != nil {
+		return fmt.Errorf("failed to create extension '%s' in database '%s': %w", extension, dbName, err)
+	}
+	return nil
+}
+
+// TruncateOptions controls TruncateAll.
+type TruncateOptions struct {
+	// Exclude lists tables to keep, e.g. reference data, as "table" to match the table
+	// in any schema, or "schema.table".
+	Exclude []string
+	// ContinueIdentity keeps the current values of sequences owned by the tables rather
+	// than restarting them.
+	ContinueIdentity bool
+}
+
+// TruncateAll deletes the rows of all the user tables in the database dbName with a
+// single TRUNCATE, keeping the schema. It is much faster than recreating the database
+// between tests. Excluded tables are never truncated: TruncateAll fails if one of them
+// has a foreign key to a table to truncate.
+func (pg *EmbeddedPostgres) TruncateAll(dbName string, opts TruncateOptions) error {
+	db, err := pg.db(dbName)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT table_schema, table_name FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')`)
+	if err != nil {
+		return fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+	}
+	defer rows.Close()
+
+	excluded := map[string]bool{}
+	for _, name := range opts.Exclude {
+		excluded[name] = true
+	}
+	var tables []string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+		}
+		if excluded[table] || excluded[schema+"."+table] {
+			continue
+		}
+		tables = append(tables, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(table))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tables in database '%s': %w", dbName, err)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	identity := "RESTART IDENTITY"
+	if opts.ContinueIdentity {
+		identity = "CONTINUE IDENTITY"
+	}
+	// Without CASCADE, which would also truncate the excluded tables referencing the
+	// others.
+	query := "TRUNCATE " + strings.Join(tables, ", ") + " " + identity
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to truncate tables in database '%s': %w", dbName, err)
+	}
+	return nil
+}
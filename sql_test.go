@@ -0,0 +1,535 @@
+package pgembed
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// countingDriver is lib/pq counting the connections it opens.
+type countingDriver struct {
+	pq.Driver
+	opened atomic.Int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	d.opened.Add(1)
+	return d.Driver.Open(name)
+}
+
+var testDriver = &countingDriver{}
+
+func init() {
+	sql.Register("pgembed-counting", testDriver)
+}
+
+func TestDriverName(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir, DriverName: "pgembed-counting"})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	before := testDriver.opened.Load()
+	if err := pg.Exec("", "SELECT 1"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if testDriver.opened.Load() == before {
+		t.Error("Exec() did not connect with the driver of DriverName")
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	version, err := pg.ServerVersion()
+	if err != nil {
+		t.Fatalf("ServerVersion() failed: %v", err)
+	}
+	if !strings.HasPrefix(version, "16.0") {
+		t.Errorf("ServerVersion() = %q, want 16.0", version)
+	}
+
+	num, err := pg.ServerVersionNum()
+	if err != nil {
+		t.Fatalf("ServerVersionNum() failed: %v", err)
+	}
+	if num != 160000 {
+		t.Errorf("ServerVersionNum() = %d, want 160000", num)
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if _, err := pg.ServerVersion(); err == nil {
+		t.Error("ServerVersion() on a stopped instance did not return an error")
+	}
+}
+
+func TestCreateExtension(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	// Creating it twice is not an error.
+	for i := 0; i < 2; i++ {
+		if err := pg.CreateExtension("postgres", "pg_trgm"); err != nil {
+			t.Fatalf("CreateExtension(pg_trgm) failed: %v", err)
+		}
+	}
+
+	db, err := pg.openDB("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM pg_extension WHERE extname = 'pg_trgm'").Scan(&count); err != nil {
+		t.Fatalf("failed to query pg_extension: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("pg_extension has %d pg_trgm rows, want 1", count)
+	}
+
+	if err := pg.CreateExtension("postgres", "postgis"); !errors.Is(err, ErrExtensionNotAvailable) {
+		t.Errorf("CreateExtension(postgis) error = %v, want ErrExtensionNotAvailable", err)
+	}
+}
+
+func TestExecAndQueryRow(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if err := pg.Exec("", "CREATE TABLE fruits (name text)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.Exec("", "INSERT INTO fruits VALUES ($1)", "apple"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	var name string
+	if err := pg.QueryRow("", "SELECT name FROM fruits WHERE name = $1", "apple").Scan(&name); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if name != "apple" {
+		t.Errorf("QueryRow() name = %q, want apple", name)
+	}
+	if len(pg.pools) != 1 {
+		t.Errorf("got %d connection pools, want 1", len(pg.pools))
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if len(pg.pools) != 0 {
+		t.Errorf("Stop() left %d connection pools open", len(pg.pools))
+	}
+}
+
+func TestQueryRowOnStoppedInstance(t *testing.T) {
+	pg := &EmbeddedPostgres{}
+	var one int
+	if err := pg.QueryRow("", "SELECT 1").Scan(&one); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("QueryRow().Scan() = %v, want an error about the instance not running", err)
+	}
+}
+
+func TestWithRollback(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.Exec("", "CREATE TABLE fruits (name text)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	err = pg.WithRollback("", func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO fruits VALUES ('apple')"); err != nil {
+			return err
+		}
+		var count int
+		if err := tx.QueryRow("SELECT count(*) FROM fruits").Scan(&count); err != nil {
+			return err
+		}
+		if count != 1 {
+			t.Errorf("got %d rows in the transaction, want 1", count)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithRollback() failed: %v", err)
+	}
+
+	var count int
+	if err := pg.QueryRow("", "SELECT count(*) FROM fruits").Scan(&count); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("got %d rows after WithRollback(), want 0", count)
+	}
+
+	errFn := errors.New("fn failed")
+	if err := pg.WithRollback("", func(*sql.Tx) error { return errFn }); err != errFn {
+		t.Errorf("WithRollback() error = %v, want the error of fn", err)
+	}
+}
+
+func TestExecBatch(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	schema := `
+CREATE TABLE counters (name text PRIMARY KEY, value int NOT NULL);
+
+CREATE FUNCTION increment(counter text) RETURNS int AS $$
+BEGIN
+    UPDATE counters SET value = value + 1 WHERE name = counter;
+    RETURN (SELECT value FROM counters WHERE name = counter);
+END;
+$$ LANGUAGE plpgsql;
+
+BEGIN;
+INSERT INTO counters VALUES ('visits', 0);
+COMMIT;
+`
+	if err := pg.ExecBatch("", schema); err != nil {
+		t.Fatalf("ExecBatch() failed: %v", err)
+	}
+	var value int
+	if err := pg.QueryRow("", "SELECT increment('visits')").Scan(&value); err != nil {
+		t.Fatalf("failed to call the function: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("increment() = %d, want 1", value)
+	}
+
+	// A failing statement rolls back the whole batch.
+	err = pg.ExecBatch("", "INSERT INTO counters VALUES ('clicks', 0); SELECT 1/0;")
+	if err == nil {
+		t.Fatal("ExecBatch() with a failing statement succeeded")
+	}
+	var count int
+	if err := pg.QueryRow("", "SELECT count(*) FROM counters").Scan(&count); err != nil {
+		t.Fatalf("failed to count counters: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("count = %d after a failed batch, want 1", count)
+	}
+}
+
+func TestCreateAndDropDatabases(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("taken", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	specs := []DatabaseSpec{{Name: "fixture_a"}, {Name: "taken"}, {Name: "fixture_b", Template: "template0"}}
+	err = pg.CreateDatabases(specs)
+	var batchErr *DatabasesError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("CreateDatabases() with an existing database error = %v, want a *DatabasesError", err)
+	}
+	if len(batchErr.Succeeded) != 2 || len(batchErr.Failed) != 1 || batchErr.Failed["taken"] == nil {
+		t.Errorf("CreateDatabases() error = %+v, want fixture_a and fixture_b to succeed and taken to fail", batchErr)
+	}
+	exists, err := pg.DatabasesExist([]string{"fixture_a", "fixture_b"})
+	if err != nil {
+		t.Fatalf("DatabasesExist() failed: %v", err)
+	}
+	if !exists["fixture_a"] || !exists["fixture_b"] {
+		t.Errorf("DatabasesExist() = %v after CreateDatabases(), want both", exists)
+	}
+
+	// An open connection must not prevent the drop.
+	if err := pg.Exec("fixture_a", "SELECT 1"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.DropDatabases([]string{"fixture_a", "fixture_b", "taken"}); err != nil {
+		t.Fatalf("DropDatabases() failed: %v", err)
+	}
+	exists, err = pg.DatabasesExist([]string{"fixture_a", "fixture_b", "taken"})
+	if err != nil {
+		t.Fatalf("DatabasesExist() failed: %v", err)
+	}
+	for name, exist := range exists {
+		if exist {
+			t.Errorf("database %s exists after DropDatabases()", name)
+		}
+	}
+
+	if err := pg.DropDatabases([]string{"fixture_a"}); !errors.As(err, &batchErr) || batchErr.Op != "drop" {
+		t.Errorf("DropDatabases() of a missing database error = %v, want a *DatabasesError", err)
+	}
+}
+
+func TestDatabasesError(t *testing.T) {
+	err := &DatabasesError{
+		Op:        "create",
+		Succeeded: []string{"a"},
+		Failed:    map[string]error{"c": errors.New("boom"), "b": sql.ErrConnDone},
+	}
+	want := "failed to create databases 'b': sql: connection is already closed; 'c': boom; succeeded: [a]"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, sql.ErrConnDone) {
+		t.Error("errors.Is() doesn't find the error of a failed database")
+	}
+}
+
+func TestTryAdvisoryLock(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	locked, unlock, err := pg.TryAdvisoryLock("", 42)
+	if err != nil || !locked {
+		t.Fatalf("TryAdvisoryLock() = %v, %v, want the lock", locked, err)
+	}
+	// Another session can't take it, whichever connection of the pool it uses.
+	if locked, _, err := pg.TryAdvisoryLock("", 42); err != nil || locked {
+		t.Errorf("second TryAdvisoryLock() = %v, %v, want not locked", locked, err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock() failed: %v", err)
+	}
+
+	locked, unlock, err = pg.TryAdvisoryLock("", 42)
+	if err != nil || !locked {
+		t.Fatalf("TryAdvisoryLock() after unlock() = %v, %v, want the lock", locked, err)
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unlock() failed: %v", err)
+	}
+}
+
+func TestTruncateAll(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	setup := `
+		CREATE TABLE countries (code text PRIMARY KEY);
+		CREATE TABLE users (id serial PRIMARY KEY, country text REFERENCES countries);
+		CREATE TABLE orders (id serial PRIMARY KEY, user_id int REFERENCES users);
+		INSERT INTO countries VALUES ('NZ');
+		INSERT INTO users (country) VALUES ('NZ');
+		INSERT INTO orders (user_id) VALUES (1);`
+	if err := pg.Exec("", setup); err != nil {
+		t.Fatalf("failed to set up tables: %v", err)
+	}
+
+	if err := pg.TruncateAll("", TruncateOptions{Exclude: []string{"countries"}}); err != nil {
+		t.Fatalf("TruncateAll() failed: %v", err)
+	}
+	for table, want := range map[string]int{"countries": 1, "users": 0, "orders": 0} {
+		var count int
+		if err := pg.QueryRow("", "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("%s has %d rows, want %d", table, count, want)
+		}
+	}
+
+	var id int
+	if err := pg.QueryRow("", "INSERT INTO users (country) VALUES ('NZ') RETURNING id").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("users id = %d after TruncateAll(), want the identity restarted at 1", id)
+	}
+}
+
+func TestTruncateAllKeepsExcludedReferencingTables(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	setup := `
+		CREATE TABLE users (id serial PRIMARY KEY);
+		CREATE TABLE audit (user_id int REFERENCES users);
+		INSERT INTO users DEFAULT VALUES;
+		INSERT INTO audit VALUES (1);`
+	if err := pg.Exec("", setup); err != nil {
+		t.Fatalf("failed to set up tables: %v", err)
+	}
+
+	if err := pg.TruncateAll("", TruncateOptions{Exclude: []string{"audit"}}); err == nil {
+		t.Error("TruncateAll() of a table referenced by an excluded table succeeded")
+	}
+	for table, want := range map[string]int{"users": 1, "audit": 1} {
+		var count int
+		if err := pg.QueryRow("", "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("%s has %d rows, want %d", table, count, want)
+		}
+	}
+}
+
+func TestConfigDatabases(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:    DefaultVersion,
+		DataDir:    dataDir,
+		RuntimeDir: dataDir,
+		Databases: []DatabaseSpec{
+			{Name: "app"},
+			{Name: "latin", Encoding: "LATIN1", Template: "template0"},
+			{Name: "copy", Template: "app"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	for _, name := range []string{"app", "latin", "copy"} {
+		exists, err := pg.DatabaseExists(name)
+		if err != nil {
+			t.Fatalf("DatabaseExists(%q) failed: %v", name, err)
+		}
+		if !exists {
+			t.Errorf("database %q was not created", name)
+		}
+	}
+	var encoding string
+	if err := pg.QueryRow("", "SELECT pg_encoding_to_char(encoding) FROM pg_database WHERE datname = 'latin'").Scan(&encoding); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "LATIN1" {
+		t.Errorf("latin database encoding = %q, want LATIN1", encoding)
+	}
+}
+
+func TestConfigDatabasesFailure(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	_, err := New(Config{
+		Version:    DefaultVersion,
+		DataDir:    dataDir,
+		RuntimeDir: dataDir,
+		Databases: []DatabaseSpec{
+			{Name: "first", Owner: "nobody"},
+			{Name: "second", Template: "missing"},
+		},
+	})
+	if err == nil {
+		t.Fatal("New() with invalid Databases succeeded")
+	}
+	for _, name := range []string{"first", "second"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("New() error %q doesn't mention database %q", err, name)
+		}
+	}
+}
+
+func TestValidateIdentifier(t *testing.T) {
+	valid := []string{"app", "App_1", "_private", "price$", strings.Repeat("a", 63)}
+	for _, name := range valid {
+		if err := validateIdentifier("database name", name); err != nil {
+			t.Errorf("validateIdentifier(%q) failed: %v", name, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"my database",
+		`quote"d`,
+		"it's",
+		"db; DROP DATABASE postgres",
+		"1st",
+		"dash-ed",
+		strings.Repeat("a", 64),
+	}
+	for _, name := range invalid {
+		if err := validateIdentifier("database name", name); err == nil {
+			t.Errorf("validateIdentifier(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+// pgxError has the SQLState method of the errors of pgx, *pgconn.PgError.
+type pgxError struct{ code string }
+
+func (e *pgxError) Error() string    { return "ERROR (SQLSTATE " + e.code + ")" }
+func (e *pgxError) SQLState() string { return e.code }
+
+func TestSQLState(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&pq.Error{Code: "42P01"}, "42P01"},
+		{fmt.Errorf("wrapped: %w", &pgxError{code: "42P01"}), "42P01"},
+		{errors.New("connection refused"), ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := sqlState(tt.err); got != tt.want {
+			t.Errorf("sqlState(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
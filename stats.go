@@ -0,0 +1,119 @@
+package pgembed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BackendActivity is what a server process is doing, a row of pg_stat_activity.
+type BackendActivity struct {
+	// PID is the process ID of the backend.
+	PID int
+	// Database the backend is connected to, empty for background processes.
+	Database string
+	// State is e.g. "active", "idle" or "idle in transaction", empty for background
+	// processes.
+	State string
+	// Query is the running query, or the last one if the backend is idle.
+	Query string
+	// WaitEventType and WaitEvent describe what the backend is waiting for, e.g. "Lock"
+	// and "relation", or are empty if it isn't waiting.
+	WaitEventType string
+	WaitEvent     string
+	// QueryStart is when Query started, zero if no query ran.
+	QueryStart time.Time
+}
+
+// Activity returns what every server process but the one running the query is doing,
+// from pg_stat_activity, e.g. to find out why a test hangs on a lock.
+func (pg *EmbeddedPostgres) Activity() ([]BackendActivity, error) {
+	db, err := pg.db("")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT pid, coalesce(datname, ''), coalesce(state, ''), coalesce(query, ''),
+		coalesce(wait_event_type, ''), coalesce(wait_event, ''), query_start
+		FROM pg_stat_activity WHERE pid <> pg_backend_pid() ORDER BY pid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pg_stat_activity: %w", err)
+	}
+	defer rows.Close()
+
+	var activity []BackendActivity
+	for rows.Next() {
+		var a BackendActivity
+		var queryStart sql.NullTime
+		if err := rows.Scan(&a.PID, &a.Database, &a.State, &a.Query, &a.WaitEventType, &a.WaitEvent, &queryStart); err != nil {
+			return nil, fmt.Errorf("failed to read pg_stat_activity: %w", err)
+		}
+		a.QueryStart = queryStart.Time
+		activity = append(activity, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_activity: %w", err)
+	}
+	return activity, nil
+}
+
+// QueryStat is the statistics of a normalized query, a row of pg_stat_statements.
+type QueryStat struct {
+	// Query is the text of the query, with constants replaced by parameters.
+	Query string
+	// Calls is the number of times the query was executed.
+	Calls int64
+	// TotalExecTime is the time spent executing the query, over all calls.
+	TotalExecTime time.Duration
+	// Rows is the number of rows retrieved or affected, over all calls.
+	Rows int64
+}
+
+// TopQueries returns the statistics of the limit queries executed in dbName that took
+// the most time in total, from pg_stat_statements, creating the extension in dbName if
+// needed. pg_stat_statements must be in Config.SharedPreloadLibraries, it can't be
+// loaded into a running server.
+func (pg *EmbeddedPostgres) TopQueries(dbName string, limit int) ([]QueryStat, error) {
+	libraries, err := pg.ShowSetting("shared_preload_libraries")
+	if err != nil {
+		return nil, err
+	}
+	var preloaded bool
+	for _, library := range strings.Split(libraries, ",") {
+		preloaded = preloaded || strings.TrimSpace(library) == "pg_stat_statements"
+	}
+	if !preloaded {
+		return nil, errors.New("pg_stat_statements is not loaded: add it to Config.SharedPreloadLibraries")
+	}
+	if err := pg.CreateExtension(dbName, "pg_stat_statements"); err != nil {
+		return nil, err
+	}
+
+	db, err := pg.db(dbName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT query, calls, total_exec_time, rows FROM pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		ORDER BY total_exec_time DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pg_stat_statements: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []QueryStat
+	for rows.Next() {
+		var s QueryStat
+		var totalExecTime float64 // in milliseconds
+		if err := rows.Scan(&s.Query, &s.Calls, &totalExecTime, &s.Rows); err != nil {
+			return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+		}
+		s.TotalExecTime = time.Duration(totalExecTime * float64(time.Millisecond))
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	return stats, nil
+}
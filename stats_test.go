@@ -0,0 +1,106 @@
+package pgembed
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivity(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	// A second connection, idle after its query.
+	db, err := pg.openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var pid int
+	if err := db.QueryRow("SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+
+	activity, err := pg.Activity()
+	if err != nil {
+		t.Fatalf("Activity() failed: %v", err)
+	}
+	var found bool
+	for _, a := range activity {
+		if a.PID == pid {
+			found = true
+			if a.State != "idle" || a.Query != "SELECT pg_backend_pid()" || a.Database != "postgres" || a.QueryStart.IsZero() {
+				t.Errorf("Activity() has %+v for the idle connection", a)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Activity() = %+v, want the idle connection %d", activity, pid)
+	}
+}
+
+func TestTopQueries(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{
+		Version:                DefaultVersion,
+		DataDir:                dataDir,
+		RuntimeDir:             dataDir,
+		SharedPreloadLibraries: []string{"pg_stat_statements"},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if _, err := pg.TopQueries("", 10); err != nil {
+		t.Fatalf("TopQueries() failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := pg.Exec("", "SELECT pg_sleep(0.01)"); err != nil {
+			t.Fatalf("Exec() failed: %v", err)
+		}
+	}
+	stats, err := pg.TopQueries("", 10)
+	if err != nil {
+		t.Fatalf("TopQueries() failed: %v", err)
+	}
+	var found bool
+	for _, s := range stats {
+		if strings.Contains(s.Query, "pg_sleep") {
+			found = true
+			if s.Calls != 3 || s.TotalExecTime < 30*time.Millisecond {
+				t.Errorf("TopQueries() has %+v, want 3 calls taking at least 30ms", s)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("TopQueries() = %+v, want the pg_sleep query", stats)
+	}
+	if len(stats) > 10 {
+		t.Errorf("TopQueries() returned %d queries, want at most 10", len(stats))
+	}
+}
+
+func TestTopQueriesWithoutPreload(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if _, err := pg.TopQueries("", 10); err == nil || !strings.Contains(err.Error(), "SharedPreloadLibraries") {
+		t.Errorf("TopQueries() error = %v, want one pointing to SharedPreloadLibraries", err)
+	}
+}
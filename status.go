@@ -0,0 +1,206 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDataDirInUse is returned by New when another running server, possibly of another
+// process, uses Config.DataDir.
+var ErrDataDirInUse = errors.New("data directory is in use by another PostgreSQL server")
+
+// Status describes the state of an instance, see EmbeddedPostgres.Status.
+type Status struct {
+	// Running reports whether the instance was started, not stopped, and its postmaster
+	// process is alive.
+	Running bool
+	// PID is the process ID of the postmaster, or 0 if it isn't running.
+	PID int
+	// Port the server is listening on, or 0 if it isn't running.
+	Port uint16
+	// Uptime is the time since the instance was started, or 0 if it isn't running.
+	Uptime time.Duration
+}
+
+// IsRunning reports whether the instance was started, not stopped, and its postmaster
+// process is still alive.
+func (pg *EmbeddedPostgres) IsRunning() bool {
+	return pg.Status().Running
+}
+
+// Status returns the state of the instance.
+func (pg *EmbeddedPostgres) Status() Status {
+	if pg.instance == nil {
+		return Status{}
+	}
+	pid, err := readPostmasterPID(pg.dataDir)
+	if err != nil || !processAlive(pid) {
+		return Status{}
+	}
+	port, _ := pg.port()
+	return Status{
+		Running: true,
+		PID:     pid,
+		Port:    port,
+		Uptime:  time.Since(pg.started),
+	}
+}
+
+// port returns the port the server is listening on, from the connection string of
+// the server layer.
+func (pg *EmbeddedPostgres) port() (uint16, error) {
+	connStr, err := serverConnectionString(pg.instance, "postgres")
+	if err != nil {
+		return 0, err
+	}
+	// The password isn't escaped, only what follows its last @ can be relied on.
+	hostPort := connStr[strings.LastIndex(connStr, "@")+1:]
+	if i := strings.Index(hostPort, "/"); i >= 0 {
+		hostPort = hostPort[:i]
+	}
+	_, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse port %q: %w", portStr, err)
+	}
+	return uint16(port), nil
+}
+
+// readPostmasterPID returns the process ID recorded on the first line of the
+// postmaster.pid file of the data directory.
+func readPostmasterPID(dataDir string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(dataDir, "postmaster.pid"))
+	if err != nil {
+		return 0, err
+	}
+	line, _, _ := strings.Cut(string(content), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0, fmt.Errorf("invalid postmaster.pid: %w", err)
+	}
+	return pid, nil
+}
+
+// orphanGracePeriod is how long CleanupOrphans waits for the server to exit after
+// SIGTERM before killing it.
+const orphanGracePeriod = 5 * time.Second
+
+// CleanupOrphans terminates the server left running in dataDir, e.g. by a process that
+// was killed before stopping it, so that it releases its port. It sends SIGTERM, then
+// SIGKILL if the server hasn't exited after a few seconds. It is meant as a best-effort
+// cleanup step, e.g. after failing CI jobs, and does nothing on Windows, where signals
+// aren't available.
+func CleanupOrphans(dataDir string) error {
+	pid, err := readPostmasterPID(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !postgresAlive(pid) {
+		return nil
+	}
+	if err := terminateProcess(pid, orphanGracePeriod); err != nil {
+		return fmt.Errorf("failed to terminate PostgreSQL process %d: %w", pid, err)
+	}
+	return nil
+}
+
+// checkDataDirLock returns ErrDataDirInUse if the postmaster.pid file of the data
+// directory belongs to a live postgres process. Otherwise the lock is stale and, if
+// removeStale is set, removed.
+func checkDataDirLock(dataDir string, removeStale bool) error {
+	pid, err := readPostmasterPID(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil && !removeStale {
+		return fmt.Errorf("failed to check the lock of data directory %s: %w", dataDir, err)
+	}
+	if err == nil && postgresAlive(pid) {
+		return fmt.Errorf("%w: %s is locked by process %d", ErrDataDirInUse, dataDir, pid)
+	}
+	if removeStale {
+		if err := os.Remove(filepath.Join(dataDir, "postmaster.pid")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale postmaster.pid: %w", err)
+		}
+	}
+	return nil
+}
+
+// StartTime returns when the server was started, as reported by the server.
+func (pg *EmbeddedPostgres) StartTime() (time.Time, error) {
+	var started time.Time
+	if err := pg.QueryRow("", "SELECT pg_postmaster_start_time()").Scan(&started); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query the server start time: %w", err)
+	}
+	return started, nil
+}
+
+// Uptime returns how long the server has been running, as reported by the server.
+func (pg *EmbeddedPostgres) Uptime() (time.Duration, error) {
+	started, err := pg.StartTime()
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(started), nil
+}
+
+// DiskUsage returns the total size in bytes of the files in the data directory,
+// including the write-ahead log, e.g. to enforce a size budget in tests.
+func (pg *EmbeddedPostgres) DiskUsage() (int64, error) {
+	if pg.instance == nil {
+		return 0, errors.New("instance is not running or has been stopped")
+	}
+	var size int64
+	err := filepath.WalkDir(pg.dataDir, func(path string, d fs.DirEntry, err error) error {
+		// The server creates and removes files while the directory is walked.
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute the size of %s: %w", pg.dataDir, err)
+	}
+	return size, nil
+}
+
+// DatabaseSize returns the disk space used by the database dbName in bytes, from
+// pg_database_size.
+func (pg *EmbeddedPostgres) DatabaseSize(dbName string) (int64, error) {
+	exists, err := pg.DatabaseExists(dbName)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("database '%s' does not exist", dbName)
+	}
+	var size int64
+	if err := pg.QueryRow("", "SELECT pg_database_size($1)", dbName).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to get the size of database '%s': %w", dbName, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,242 @@
+package pgembed
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if !pg.IsRunning() {
+		t.Error("IsRunning() = false for a started instance")
+	}
+	status := pg.Status()
+	if !status.Running || status.PID == 0 || status.Port == 0 || status.Uptime <= 0 {
+		t.Errorf("Status() = %+v, want a running instance", status)
+	}
+	if status.PID == os.Getpid() || !processAlive(status.PID) {
+		t.Errorf("Status().PID = %d is not the postmaster", status.PID)
+	}
+
+	started, err := pg.StartTime()
+	if err != nil {
+		t.Fatalf("StartTime() failed: %v", err)
+	}
+	if age := time.Since(started); age < 0 || age > time.Minute {
+		t.Errorf("StartTime() = %v, want shortly before now", started)
+	}
+	uptime, err := pg.Uptime()
+	if err != nil {
+		t.Fatalf("Uptime() failed: %v", err)
+	}
+	if uptime <= 0 || uptime > time.Minute {
+		t.Errorf("Uptime() = %v, want a short positive duration", uptime)
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if pg.IsRunning() {
+		t.Error("IsRunning() = true for a stopped instance")
+	}
+	if status := pg.Status(); status != (Status{}) {
+		t.Errorf("Status() = %+v for a stopped instance, want the zero Status", status)
+	}
+	if _, err := pg.Uptime(); err == nil {
+		t.Error("Uptime() of a stopped instance succeeded")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	before, err := pg.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage() failed: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE filler AS SELECT repeat('x', 1000) AS data FROM generate_series(1, 10000)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	after, err := pg.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage() failed: %v", err)
+	}
+	if before <= 0 || after < before+5_000_000 {
+		t.Errorf("DiskUsage() = %d before and %d after writing 10MB", before, after)
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if _, err := pg.DiskUsage(); err == nil {
+		t.Error("DiskUsage() of a stopped instance succeeded")
+	}
+}
+
+func TestDatabaseSize(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	if err := pg.CreateDatabase("sized", ""); err != nil {
+		t.Fatalf("CreateDatabase() failed: %v", err)
+	}
+	before, err := pg.DatabaseSize("sized")
+	if err != nil {
+		t.Fatalf("DatabaseSize() failed: %v", err)
+	}
+	if err := pg.Exec("sized", "CREATE TABLE filler AS SELECT repeat('x', 1000) AS data FROM generate_series(1, 10000)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	after, err := pg.DatabaseSize("sized")
+	if err != nil {
+		t.Fatalf("DatabaseSize() failed: %v", err)
+	}
+	if before <= 0 || after < before+5_000_000 {
+		t.Errorf("DatabaseSize() = %d before and %d after writing 10MB", before, after)
+	}
+
+	_, err = pg.DatabaseSize("missing")
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("DatabaseSize() of a missing database error = %v, want does not exist", err)
+	}
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("processAlive() = false for the current process")
+	}
+	if processAlive(0) {
+		t.Error("processAlive(0) = true")
+	}
+}
+
+func TestDataDirInUse(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	_, err = New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if !errors.Is(err, ErrDataDirInUse) {
+		t.Errorf("New() on a data directory in use = %v, want ErrDataDirInUse", err)
+	}
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pidFile := filepath.Join(dataDir, "postmaster.pid")
+	// No process has the maximum PID.
+	stale := "2147483647\n" + dataDir + "\n"
+	if err := os.WriteFile(pidFile, []byte(stale), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkDataDirLock(dataDir, false); err != nil {
+		t.Fatalf("checkDataDirLock() failed: %v", err)
+	}
+	if _, err := os.Stat(pidFile); err != nil {
+		t.Errorf("checkDataDirLock() without removeStale removed the lock: %v", err)
+	}
+
+	if err := checkDataDirLock(dataDir, true); err != nil {
+		t.Fatalf("checkDataDirLock() failed: %v", err)
+	}
+	if _, err := os.Stat(pidFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkDataDirLock() with removeStale left the stale lock: %v", err)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals aren't available on Windows")
+	}
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+	pid := pg.Status().PID
+
+	if err := CleanupOrphans(dataDir); err != nil {
+		t.Fatalf("CleanupOrphans() failed: %v", err)
+	}
+	if processAlive(pid) {
+		t.Errorf("postmaster %d is still alive after CleanupOrphans()", pid)
+	}
+	if err := CleanupOrphans(t.TempDir()); err != nil {
+		t.Errorf("CleanupOrphans() of a directory without a server failed: %v", err)
+	}
+}
+
+func TestStopMode(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	config := Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir}
+	pg, err := New(config)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE crash (id int); INSERT INTO crash VALUES (1)"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.StopMode("sudden"); err == nil {
+		t.Error("StopMode() with an invalid mode succeeded")
+	}
+	if err := pg.StopMode(ShutdownImmediate); err != nil {
+		t.Fatalf("StopMode(ShutdownImmediate) failed: %v", err)
+	}
+	if pg.IsRunning() {
+		t.Fatal("instance is running after StopMode(ShutdownImmediate)")
+	}
+
+	// The next start recovers the committed data.
+	pg, err = New(config)
+	if err != nil {
+		t.Fatalf("New() after an immediate shutdown failed: %v", err)
+	}
+	defer pg.Stop()
+	var id int
+	if err := pg.QueryRow("", "SELECT id FROM crash").Scan(&id); err != nil {
+		t.Fatalf("failed to query after recovery: %v", err)
+	}
+	if id != 1 {
+		t.Errorf("id = %d after recovery, want 1", id)
+	}
+}
@@ -0,0 +1,192 @@
+package pgembed
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSConfig configures the server to accept TLS (SSL) connections.
+type TLSConfig struct {
+	// CertFile is the path to the PEM encoded server certificate. Mandatory.
+	CertFile string
+	// KeyFile is the path to the PEM encoded private key of CertFile. Mandatory.
+	KeyFile string
+	// CAFile is the path to the PEM encoded certificate of the CA that signed CertFile.
+	// When set, connection strings verify the server certificate (sslmode=verify-full)
+	// instead of only requiring encryption (sslmode=require), unless Config.SSLMode
+	// says otherwise.
+	CAFile string
+	// SelfSigned generates a self-signed certificate for localhost instead of using
+	// CertFile and KeyFile, which must then be empty. The certificate and key are
+	// written to the RuntimeDir (or the DataDir when it isn't set), and the
+	// certificate, which is also its own CA, can be retrieved with
+	// EmbeddedPostgres.CACertPEM.
+	SelfSigned bool
+}
+
+// The files of the TLSConfig are copied into the data directory under these
+// names, so the server can read them, and the key gets the 0600 permissions the
+// server insists on.
+const (
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+	serverCAFile   = "root.crt"
+)
+
+// serverSettings returns the postgresql.conf settings enabling TLS.
+func (c *TLSConfig) serverSettings() map[string]string {
+	settings := map[string]string{
+		"ssl":           "on",
+		"ssl_cert_file": serverCertFile,
+		"ssl_key_file":  serverKeyFile,
+	}
+	if c.CAFile != "" {
+		settings["ssl_ca_file"] = serverCAFile
+	}
+	return settings
+}
+
+// The names of the files written by generateSelfSigned.
+const (
+	selfSignedCertFile = "pgembed-server.crt"
+	selfSignedKeyFile  = "pgembed-server.key"
+)
+
+// generateSelfSigned generates a self-signed certificate for localhost into dir
+// and points CertFile, KeyFile and CAFile at it.
+func (c *TLSConfig) generateSelfSigned(dir string) error {
+	if c.CertFile != "" || c.KeyFile != "" {
+		return errors.New("TLS.SelfSigned cannot be combined with CertFile or KeyFile")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"go-pgembed"}},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create TLS certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode TLS key: %w", err)
+	}
+
+	certFile := filepath.Join(dir, selfSignedCertFile)
+	keyFile := filepath.Join(dir, selfSignedKeyFile)
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		return fmt.Errorf("failed to write TLS certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write TLS key: %w", err)
+	}
+	c.CertFile, c.KeyFile, c.CAFile = certFile, keyFile, certFile
+	return nil
+}
+
+// installFiles copies the certificate files into dataDir.
+func (c *TLSConfig) installFiles(dataDir string) error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("TLS requires both CertFile and KeyFile")
+	}
+	files := map[string]string{
+		c.CertFile: serverCertFile,
+		c.KeyFile:  serverKeyFile,
+	}
+	if c.CAFile != "" {
+		files[c.CAFile] = serverCAFile
+	}
+	for src, name := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dataDir, name), data, 0600); err != nil {
+			return fmt.Errorf("failed to install TLS file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sslModes are the supported values of Config.SSLMode.
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// sslMode returns the sslmode connection strings use: Config.SSLMode if set,
+// otherwise derived from whether TLS is enabled.
+func (c Config) sslMode() string {
+	switch {
+	case c.SSLMode != "":
+		return c.SSLMode
+	case c.TLS == nil:
+		return "disable"
+	case c.TLS.CAFile != "":
+		return "verify-full"
+	default:
+		return "require"
+	}
+}
+
+// sslParams returns the SSL related connection string parameters.
+func (c Config) sslParams() (url.Values, error) {
+	params := url.Values{}
+	mode := c.sslMode()
+	params.Set("sslmode", mode)
+	if (mode == "verify-ca" || mode == "verify-full") && c.TLS != nil && c.TLS.CAFile != "" {
+		absCAFile, err := filepath.Abs(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for CAFile: %w", err)
+		}
+		params.Set("sslrootcert", absCAFile)
+	}
+	return params, nil
+}
+
+// withParams sets params on the query of the URL connection string dsn,
+// replacing any existing values of the same parameters.
+func withParams(dsn string, params url.Values) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,180 @@
+package pgembed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BinDir returns the directory holding the PostgreSQL binaries of the instance, e.g.
+// psql, pg_dump or pg_basebackup, for running tools the package doesn't wrap.
+func (pg *EmbeddedPostgres) BinDir() (string, error) {
+	if pg.instance == nil {
+		return "", errors.New("instance is not running or has been stopped")
+	}
+	return pg.binDir, nil
+}
+
+// DumpFormat is the output format of Dump.
+type DumpFormat string
+
+const (
+	// DumpFormatPlain is a plain-text SQL script, restored with psql.
+	DumpFormatPlain DumpFormat = "plain"
+	// DumpFormatCustom is pg_dump's compressed custom archive format, restored with
+	// pg_restore.
+	DumpFormatCustom DumpFormat = "custom"
+)
+
+// DumpOptions controls what Dump writes.
+type DumpOptions struct {
+	// SchemaOnly dumps only the object definitions, not the data.
+	SchemaOnly bool
+	// DataOnly dumps only the data, not the object definitions.
+	DataOnly bool
+	// Format of the dump. Defaults to DumpFormatPlain.
+	Format DumpFormat
+}
+
+// Dump writes the contents of the database dbName to outPath using the pg_dump of the
+// PostgreSQL binaries.
+func (pg *EmbeddedPostgres) Dump(dbName, outPath string, opts DumpOptions) error {
+	if opts.SchemaOnly && opts.DataOnly {
+		return errors.New("SchemaOnly and DataOnly are mutually exclusive")
+	}
+	format := opts.Format
+	if format == "" {
+		format = DumpFormatPlain
+	}
+	if format != DumpFormatPlain && format != DumpFormatCustom {
+		return fmt.Errorf("unsupported dump format %q", format)
+	}
+	dsn, err := pg.toolDSN(dbName)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--dbname=" + dsn, "--file=" + outPath, "--format=" + string(format)}
+	if opts.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if opts.DataOnly {
+		args = append(args, "--data-only")
+	}
+	return pg.runTool("pg_dump", args...)
+}
+
+// RestoreOptions controls how Restore loads a dump.
+type RestoreOptions struct {
+	// CreateDatabase creates the target database first if it doesn't exist.
+	CreateDatabase bool
+	// Format of the dump. If empty, it is detected from the file.
+	Format DumpFormat
+}
+
+// Restore loads a dump written by pg_dump, e.g. with Dump, into the database dbName:
+// plain-text dumps with psql, custom archives with pg_restore.
+func (pg *EmbeddedPostgres) Restore(dbName, inPath string, opts RestoreOptions) error {
+	format := opts.Format
+	if format == "" {
+		var err error
+		if format, err = detectDumpFormat(inPath); err != nil {
+			return err
+		}
+	}
+	if format != DumpFormatPlain && format != DumpFormatCustom {
+		return fmt.Errorf("unsupported dump format %q", format)
+	}
+
+	if opts.CreateDatabase && dbName != "" {
+		exists, err := pg.DatabaseExists(dbName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := pg.CreateDatabase(dbName, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if format == DumpFormatPlain {
+		return pg.RunSQLFile(dbName, inPath)
+	}
+	dsn, err := pg.toolDSN(dbName)
+	if err != nil {
+		return err
+	}
+	return pg.runTool("pg_restore", "--dbname="+dsn, "--exit-on-error", inPath)
+}
+
+// RunSQLFile runs the SQL script at path in the database dbName with the psql of the
+// PostgreSQL binaries, so that psql meta-commands such as \i, \ir or \copy work. It
+// stops at the first error, which includes the output of psql.
+func (pg *EmbeddedPostgres) RunSQLFile(dbName, path string) error {
+	dsn, err := pg.toolDSN(dbName)
+	if err != nil {
+		return err
+	}
+	return pg.runTool("psql", "--dbname="+dsn, "--file="+path, "--no-psqlrc", "--quiet", "--set=ON_ERROR_STOP=1")
+}
+
+// detectDumpFormat returns the format of the dump in path. Custom archives start
+// with "PGDMP".
+func detectDumpFormat(path string) (DumpFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 5)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read dump: %w", err)
+	}
+	if string(header[:n]) == "PGDMP" {
+		return DumpFormatCustom, nil
+	}
+	return DumpFormatPlain, nil
+}
+
+// toolDSN returns the connection string to dbName for the client tools. It has no
+// password, which runToolIO passes in PGPASSWORD instead, so that it doesn't show in
+// the arguments of the tool, which other local users can read.
+func (pg *EmbeddedPostgres) toolDSN(dbName string) (string, error) {
+	params, err := pg.ConnectionParams(dbName)
+	if err != nil {
+		return "", err
+	}
+	params.Password = ""
+	return params.url("postgresql")
+}
+
+// runTool runs one of the PostgreSQL client tools, returning its stderr on failure.
+func (pg *EmbeddedPostgres) runTool(name string, args ...string) error {
+	return pg.runToolIO(nil, nil, name, args...)
+}
+
+// runToolIO is runTool with the standard input and output of the tool connected to
+// stdin and stdout, which may be nil.
+func (pg *EmbeddedPostgres) runToolIO(stdin io.Reader, stdout io.Writer, name string, args ...string) error {
+	binDir, err := pg.BinDir()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(filepath.Join(binDir, executable(name)), args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+pg.password)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package pgembed
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinDir(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	binDir, err := pg.BinDir()
+	if err != nil {
+		t.Fatalf("BinDir() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(binDir, executable("psql"))); err != nil {
+		t.Errorf("psql not found in BinDir(): %v", err)
+	}
+
+	if err := pg.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	if _, err := pg.BinDir(); err == nil {
+		t.Error("BinDir() of a stopped instance succeeded")
+	}
+}
+
+func TestDump(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	db, err := pg.openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE fruits (name text); INSERT INTO fruits VALUES ('apple')"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	schemaPath := filepath.Join(dataDir, "schema.sql")
+	if err := pg.Dump("", schemaPath, DumpOptions{SchemaOnly: true}); err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	schema, err := os.ReadFile(schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(schema), "CREATE TABLE public.fruits") {
+		t.Errorf("schema dump doesn't create the fruits table:\n%s", schema)
+	}
+	if strings.Contains(string(schema), "apple") {
+		t.Errorf("schema dump contains data:\n%s", schema)
+	}
+
+	if err := pg.Dump("missing", filepath.Join(dataDir, "missing.sql"), DumpOptions{}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Dump() of a missing database = %v, want the pg_dump error", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	db, err := pg.openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE fruits (name text); INSERT INTO fruits VALUES ('apple')"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	for _, format := range []DumpFormat{DumpFormatPlain, DumpFormatCustom} {
+		t.Run(string(format), func(t *testing.T) {
+			dumpPath := filepath.Join(dataDir, "dump."+string(format))
+			if err := pg.Dump("", dumpPath, DumpOptions{Format: format}); err != nil {
+				t.Fatalf("Dump() failed: %v", err)
+			}
+			dbName := "restored_" + string(format)
+			if err := pg.Restore(dbName, dumpPath, RestoreOptions{CreateDatabase: true}); err != nil {
+				t.Fatalf("Restore() failed: %v", err)
+			}
+
+			restored, err := pg.openDB(dbName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer restored.Close()
+			var name string
+			if err := restored.QueryRow("SELECT name FROM fruits").Scan(&name); err != nil {
+				t.Fatalf("failed to query restored table: %v", err)
+			}
+			if name != "apple" {
+				t.Errorf("restored name = %q, want apple", name)
+			}
+		})
+	}
+
+	if err := pg.Restore("", filepath.Join(dataDir, "missing.sql"), RestoreOptions{}); err == nil {
+		t.Error("Restore() of a missing file succeeded")
+	}
+}
+
+func TestRunSQLFile(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	scriptDir := t.TempDir()
+	csvPath := filepath.Join(scriptDir, "fruits.csv")
+	if err := os.WriteFile(csvPath, []byte("apple\nbanana\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(scriptDir, "schema.sql"), []byte("CREATE TABLE fruits (name text);\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	script := "\\ir schema.sql\n\\copy fruits FROM '" + csvPath + "' WITH (FORMAT csv)\n"
+	scriptPath := filepath.Join(scriptDir, "main.sql")
+	if err := os.WriteFile(scriptPath, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pg.RunSQLFile("", scriptPath); err != nil {
+		t.Fatalf("RunSQLFile() failed: %v", err)
+	}
+	var count int
+	if err := pg.QueryRow("", "SELECT count(*) FROM fruits").Scan(&count); err != nil {
+		t.Fatalf("failed to count fruits: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+
+	badPath := filepath.Join(scriptDir, "bad.sql")
+	if err := os.WriteFile(badPath, []byte("SELECT * FROM missing;\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = pg.RunSQLFile("", badPath)
+	if err == nil || !strings.Contains(err.Error(), `relation "missing" does not exist`) {
+		t.Errorf("RunSQLFile() of a failing script error = %v, want psql's error", err)
+	}
+}
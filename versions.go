@@ -0,0 +1,201 @@
+package pgembed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrVersionNotFound is returned by New when no PostgreSQL release matches
+// Config.Version.
+var ErrVersionNotFound = errors.New("PostgreSQL version not found")
+
+// DefaultVersion is the PostgreSQL version the package recommends and is tested with.
+// It is used when Config.Version is empty and Config.UseDefaultVersion is set.
+const DefaultVersion = "16.0.0"
+
+// LatestSupportedVersion returns the newest PostgreSQL version this release of the
+// package is tested with, currently DefaultVersion. Newer versions usually work too.
+func LatestSupportedVersion() string {
+	return DefaultVersion
+}
+
+// LatestVersion can be used as Config.Version to select the newest release.
+const LatestVersion = "latest"
+
+// versionSelector matches the accepted forms of Config.Version besides
+// LatestVersion: "16", "16.2" or "16.2.0".
+var versionSelector = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// releasesAPIURL lists the releases of the repository the binaries are
+// downloaded from.
+var releasesAPIURL = "https://api.github.com/repos/theseus-rs/postgresql-binaries/releases"
+
+var (
+	availableVersionsMu sync.Mutex
+	availableVersions   []string
+)
+
+// AvailableVersions returns the PostgreSQL versions that can be downloaded, sorted
+// from oldest to newest, e.g. ["...", "16.0.0", "16.1.0", ...]. The list is fetched
+// from GitHub once and cached for the lifetime of the process. Set the GITHUB_TOKEN
+// environment variable to avoid GitHub's rate limit for anonymous requests.
+func AvailableVersions() ([]string, error) {
+	availableVersionsMu.Lock()
+	defer availableVersionsMu.Unlock()
+
+	if availableVersions == nil {
+		versions, err := fetchVersions()
+		if err != nil {
+			return nil, err
+		}
+		availableVersions = versions
+	}
+	return append([]string(nil), availableVersions...), nil
+}
+
+// fetchVersions pages through the GitHub releases and returns the sorted
+// release versions.
+func fetchVersions() ([]string, error) {
+	var versions []string
+	for page := 1; ; page++ {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?per_page=100&page=%d", releasesAPIURL, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list PostgreSQL versions: %w", err)
+		}
+		var releases []struct {
+			TagName string `json:"tag_name"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list PostgreSQL versions: %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&releases)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PostgreSQL versions: %w", err)
+		}
+
+		if len(releases) == 0 {
+			break
+		}
+		for _, release := range releases {
+			if exactVersion.MatchString(release.TagName) {
+				versions = append(versions, release.TagName)
+			}
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions, nil
+}
+
+// compareVersions compares two exact versions numerically, returning -1, 0 or
+// +1 like strings.Compare.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, _ := strconv.Atoi(as[i])
+		bn, _ := strconv.Atoi(bs[i])
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// resolveVersion resolves config.Version to an exact version. A major (or
+// major.minor) version such as "16" resolves to the newest matching release,
+// and LatestVersion to the newest release overall. Offline configurations
+// resolve against the versions in the cache rather than the downloadable ones.
+// The releases of a DownloadBaseURL mirror can't be listed, so it requires an
+// exact version.
+func resolveVersion(config Config) (string, error) {
+	if exactVersion.MatchString(config.Version) {
+		return config.Version, nil
+	}
+	if config.Version != LatestVersion && !versionSelector.MatchString(config.Version) {
+		return "", fmt.Errorf("invalid Version %q: expected a version such as \"16.2.0\", \"16\" or %q", config.Version, LatestVersion)
+	}
+	if config.DownloadBaseURL != "" && !config.Offline {
+		return "", fmt.Errorf("invalid Version %q: DownloadBaseURL requires an exact version such as %q", config.Version, DefaultVersion)
+	}
+
+	var candidates []string
+	if config.Offline {
+		cacheDir, err := cacheDirectory(config)
+		if err != nil {
+			return "", err
+		}
+		if candidates, err = cachedVersions(cacheDir); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		if candidates, err = AvailableVersions(); err != nil {
+			return "", err
+		}
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if config.Version == LatestVersion || strings.HasPrefix(candidates[i], config.Version+".") {
+			return candidates[i], nil
+		}
+	}
+	return "", fmt.Errorf("%w: no release matches %q", ErrVersionNotFound, config.Version)
+}
+
+// CachedVersions returns the sorted versions whose binaries are in cacheDir, or the
+// default cache directory if it is empty, e.g. to check that New with Config.Offline
+// will find the version it needs.
+func CachedVersions(cacheDir string) ([]string, error) {
+	cacheDir, err := cacheDirectory(Config{CacheDir: cacheDir})
+	if err != nil {
+		return nil, err
+	}
+	return cachedVersions(cacheDir)
+}
+
+// cachedVersions returns the sorted versions installed in cacheDir.
+func cachedVersions(cacheDir string) ([]string, error) {
+	entries, err := os.ReadDir(cacheDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory %s: %w", cacheDir, err)
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if exactVersion.MatchString(entry.Name()) && hasBinaries(filepath.Join(cacheDir, entry.Name())) {
+			versions = append(versions, entry.Name())
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions, nil
+}
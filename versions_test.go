@@ -0,0 +1,149 @@
+package pgembed
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchVersions(t *testing.T) {
+	pages := [][]string{
+		{"16.1.0", "9.6.24", "16.0.0"},
+		{"15.10.0", "15.9.0", "not-a-version"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		w.Write([]byte("["))
+		if page >= 1 && page <= len(pages) {
+			for i, tag := range pages[page-1] {
+				if i > 0 {
+					w.Write([]byte(","))
+				}
+				fmt.Fprintf(w, `{"tag_name": %q}`, tag)
+			}
+		}
+		w.Write([]byte("]"))
+	}))
+	defer server.Close()
+
+	defer func(url string) { releasesAPIURL = url }(releasesAPIURL)
+	releasesAPIURL = server.URL
+
+	versions, err := fetchVersions()
+	if err != nil {
+		t.Fatalf("fetchVersions() failed: %v", err)
+	}
+	want := []string{"9.6.24", "15.9.0", "15.10.0", "16.0.0", "16.1.0"}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("fetchVersions() = %v, want %v", versions, want)
+	}
+}
+
+func TestAvailableVersionsContainsPinnedVersion(t *testing.T) {
+	versions, err := AvailableVersions()
+	if err != nil {
+		t.Fatalf("AvailableVersions() failed: %v", err)
+	}
+	for _, version := range versions {
+		if version == "16.0.0" {
+			return
+		}
+	}
+	t.Errorf("AvailableVersions() = %v, does not contain 16.0.0", versions)
+}
+
+func TestResolveVersionOffline(t *testing.T) {
+	cacheDir := tempDir(t)
+	defer os.RemoveAll(cacheDir)
+	for _, version := range []string{"15.1.0", "16.0.0", "16.2.0", "16.10.0"} {
+		binDir := filepath.Join(cacheDir, version, "bin")
+		if err := os.MkdirAll(binDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(binDir, executable("pg_ctl")), nil, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct{ version, want string }{
+		{"16.0.0", "16.0.0"},
+		{"16", "16.10.0"},
+		{"15.1", "15.1.0"},
+		{LatestVersion, "16.10.0"},
+	}
+	for _, tt := range tests {
+		got, err := resolveVersion(Config{Version: tt.version, CacheDir: cacheDir, Offline: true})
+		if err != nil {
+			t.Errorf("resolveVersion(%q) failed: %v", tt.version, err)
+		}
+		if got != tt.want {
+			t.Errorf("resolveVersion(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+
+	if _, err := resolveVersion(Config{Version: "17", CacheDir: cacheDir, Offline: true}); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("resolveVersion(\"17\") error = %v, want ErrVersionNotFound", err)
+	}
+	if _, err := resolveVersion(Config{Version: "v16", CacheDir: cacheDir, Offline: true}); err == nil {
+		t.Error("resolveVersion(\"v16\") did not return an error")
+	}
+}
+
+func TestResolveVersionWithMirror(t *testing.T) {
+	// The GitHub releases must not be listed: they are unreachable here.
+	defer func(url string) { releasesAPIURL = url }(releasesAPIURL)
+	releasesAPIURL = "http://127.0.0.1:1"
+
+	config := Config{DownloadBaseURL: "https://mirror.example.com/{version}.tar.gz"}
+	for _, version := range []string{"16", "16.2", LatestVersion} {
+		config.Version = version
+		if _, err := resolveVersion(config); err == nil || !strings.Contains(err.Error(), "exact version") {
+			t.Errorf("resolveVersion(%q) with a mirror error = %v, want an exact version error", version, err)
+		}
+	}
+	config.Version = "16.2.0"
+	if got, err := resolveVersion(config); err != nil || got != "16.2.0" {
+		t.Errorf("resolveVersion(\"16.2.0\") with a mirror = %q, %v, want \"16.2.0\"", got, err)
+	}
+}
+
+func TestNewWithMajorVersion(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: "16", DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	version, err := pg.ServerVersion()
+	if err != nil {
+		t.Fatalf("ServerVersion() failed: %v", err)
+	}
+	if !strings.HasPrefix(version, "16.") {
+		t.Errorf("ServerVersion() = %q, want a 16.x.y version", version)
+	}
+	if resolved := pg.ResolvedVersion(); !exactVersion.MatchString(resolved) || !strings.HasPrefix(resolved, "16.") {
+		t.Errorf("ResolvedVersion() = %q, want a 16.x.y version", resolved)
+	}
+}
+
+func TestUseDefaultVersion(t *testing.T) {
+	if err := (Config{UseDefaultVersion: true}).Validate(); err != nil {
+		t.Errorf("Validate() with UseDefaultVersion failed: %v", err)
+	}
+	if err := (Config{}).Validate(); err == nil {
+		t.Error("Validate() without Version or UseDefaultVersion succeeded")
+	}
+	if !exactVersion.MatchString(DefaultVersion) || LatestSupportedVersion() != DefaultVersion {
+		t.Errorf("DefaultVersion = %q, LatestSupportedVersion() = %q", DefaultVersion, LatestSupportedVersion())
+	}
+}
@@ -0,0 +1,29 @@
+package pgembed
+
+import "fmt"
+
+// Checkpoint forces a checkpoint with CHECKPOINT, flushing all dirty buffers to disk.
+func (pg *EmbeddedPostgres) Checkpoint() error {
+	return pg.Exec("", "CHECKPOINT")
+}
+
+// SwitchWAL switches to a new write-ahead log file with pg_switch_wal() and returns
+// the end location of the completed one, e.g. "0/1A3B2C8". Nothing is switched if the
+// current file is empty.
+func (pg *EmbeddedPostgres) SwitchWAL() (string, error) {
+	var lsn string
+	if err := pg.QueryRow("", "SELECT pg_switch_wal()::text").Scan(&lsn); err != nil {
+		return "", fmt.Errorf("failed to switch WAL: %w", err)
+	}
+	return lsn, nil
+}
+
+// CurrentLSN returns the current write-ahead log write location from
+// pg_current_wal_lsn(), e.g. "0/1A3B2C8".
+func (pg *EmbeddedPostgres) CurrentLSN() (string, error) {
+	var lsn string
+	if err := pg.QueryRow("", "SELECT pg_current_wal_lsn()::text").Scan(&lsn); err != nil {
+		return "", fmt.Errorf("failed to get current WAL LSN: %w", err)
+	}
+	return lsn, nil
+}
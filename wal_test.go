@@ -0,0 +1,43 @@
+package pgembed
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWAL(t *testing.T) {
+	dataDir := tempDir(t)
+	defer os.RemoveAll(dataDir)
+
+	pg, err := New(Config{Version: DefaultVersion, DataDir: dataDir, RuntimeDir: dataDir})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer pg.Stop()
+
+	before, err := pg.CurrentLSN()
+	if err != nil {
+		t.Fatalf("CurrentLSN() failed: %v", err)
+	}
+	if err := pg.Exec("", "CREATE TABLE events AS SELECT generate_series(1, 1000) AS id"); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := pg.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	if _, err := pg.SwitchWAL(); err != nil {
+		t.Fatalf("SwitchWAL() failed: %v", err)
+	}
+	after, err := pg.CurrentLSN()
+	if err != nil {
+		t.Fatalf("CurrentLSN() failed: %v", err)
+	}
+
+	var advanced bool
+	if err := pg.QueryRow("", "SELECT $1::pg_lsn > $2::pg_lsn", after, before).Scan(&advanced); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if !advanced {
+		t.Errorf("CurrentLSN() = %s after writing, want more than %s", after, before)
+	}
+}